@@ -17,6 +17,20 @@ function initWasm() {
     'circle': circle,
     'rect': rect,
     'color': color,
+    'stroke': stroke,
+    'fill': fill,
+    'polygon': polygon,
+    'polyline': polyline,
+    'curve': curve,
+    'text': text,
+    'textsize': textsize,
+    'clear': clear,
+    'save': save,
+    'restore': restore,
+    'coordinates': coordinates,
+    'registerEventHandler': registerEventHandler,
+    'sleep': sleep,
+    'readid': readid,
   }
   document.querySelectorAll('header button').forEach((button) => {
     button.onclick = handleRun
@@ -36,6 +50,19 @@ function jsPrint(ptr, len) {
   }
 }
 
+// readid writes the value of the DOM element with the given query
+// selector to wasm memory at ptr, up to size bytes. It returns the full
+// length of the UTF-8 encoded value, so that wasm can retry with a
+// larger buffer.
+function readid(selPtr, selLen, ptr, size) {
+  const el = document.querySelector(memString(selPtr, selLen))
+  const value = el ? el.value ?? el.textContent : ''
+  const bytes = new TextEncoder('utf8').encode(value)
+  const mem = new Uint8Array(wasm.exports.memory.buffer, ptr, size)
+  mem.set(bytes.subarray(0, size))
+  return bytes.length
+}
+
 function memString(ptr, len) {
   const buf = new Uint8Array(wasm.exports.memory.buffer, ptr, len)
   const s = new TextDecoder('utf8').decode(buf)
@@ -43,7 +70,8 @@ function memString(ptr, len) {
 }
 
 // handleRun retrieves the input string from the code pane and
-// converts it to wasm memory bytes. It then calls the evy evaluate
+// converts it to wasm memory bytes. It then stops the currently running
+// evy program, if any, and calls the evy evaluate, tokenize or parse
 // function.
 function handleRun(event) {
   const code = document.getElementById('code').value
@@ -52,11 +80,101 @@ function handleRun(event) {
   const mem = new Uint8Array(wasm.exports.memory.buffer, ptr, bytes.length)
   mem.set(new Uint8Array(bytes))
   document.getElementById('output').textContent = ''
+  wasm.exports.stop()
   resetCanvas()
+  removeEventHandlers()
+  clearTimeout(sleepTimeout)
   const fn = wasm.exports[event.target.id] // evaluate, tokenize or parse
   fn(ptr, bytes.length)
 }
 
+// sleepTimeout is the ID of the timeout ending the current evy sleep.
+let sleepTimeout
+
+// sleep is called from wasm when the evy program sleeps. It returns
+// immediately, so that the browser can paint and handle events, and
+// wakes up the evy program after ms milliseconds.
+function sleep(ms) {
+  sleepTimeout = setTimeout(() => wasm.exports.wakeup(), ms)
+}
+
+// --------------------------------------------------
+// event handling
+
+// eventHandlers holds the DOM event listeners added for the evy event
+// handlers of the most recently evaluated program.
+let eventHandlers = []
+
+// registerEventHandler is called from wasm for every evy event handler,
+// e.g. `on key_press`, and adds the corresponding DOM event listener.
+function registerEventHandler(ptr, len) {
+  const name = memString(ptr, len)
+  const c = document.getElementById('canvas')
+  if (name === 'key_press') {
+    addEventHandler(document, 'keydown', keydownListener)
+  } else if (name === 'mouse_down') {
+    addEventHandler(c, 'mousedown', mouseListener(wasm.exports.onMouseDown))
+  } else if (name === 'mouse_up') {
+    addEventHandler(c, 'mouseup', mouseListener(wasm.exports.onMouseUp))
+  } else if (name === 'mouse_move') {
+    addEventHandler(c, 'mousemove', mouseListener(wasm.exports.onMouseMove))
+  } else if (name === 'frame') {
+    startFrames()
+  } else {
+    console.error('cannot register unknown event', name)
+  }
+}
+
+function addEventHandler(target, type, listener) {
+  target.addEventListener(type, listener)
+  eventHandlers.push({ target, type, listener })
+}
+
+function removeEventHandlers() {
+  for (const { target, type, listener } of eventHandlers) {
+    target.removeEventListener(type, listener)
+  }
+  eventHandlers = []
+  cancelAnimationFrame(animationFrame)
+}
+
+// animationFrame is the request ID of the next evy frame event.
+let animationFrame
+
+// startFrames calls the evy frame event handler on every animation
+// frame with the seconds elapsed since the first frame, until the evy
+// program stops, e.g. with `exit`, or a new program is run.
+function startFrames() {
+  let start
+  const loop = (timestamp) => {
+    start ??= timestamp
+    if (wasm.exports.onFrame((timestamp - start) / 1000)) {
+      animationFrame = requestAnimationFrame(loop)
+    }
+  }
+  animationFrame = requestAnimationFrame(loop)
+}
+
+function keydownListener(e) {
+  if (e.target.id === 'code') return // ignore typing in the code pane
+  const bytes = new TextEncoder('utf8').encode(e.key)
+  const ptr = wasm.exports.alloc(bytes.length)
+  const mem = new Uint8Array(wasm.exports.memory.buffer, ptr, bytes.length)
+  mem.set(new Uint8Array(bytes))
+  wasm.exports.onKeyPress(ptr, bytes.length)
+}
+
+// mouseListener returns a listener calling fn with the mouse
+// coordinates converted to evy's coordinate system.
+function mouseListener(fn) {
+  return (e) => {
+    const rect = e.target.getBoundingClientRect()
+    const px = ((e.clientX - rect.left) / rect.width) * e.target.width
+    const py = ((e.clientY - rect.top) / rect.height) * e.target.height
+    fn(px / canvas.scale.x - canvas.offset.x, py / canvas.scale.y - canvas.offset.y)
+  }
+}
+
 // --------------------------------------------------
 // confetti easter egg
 // When code input string contains the sub string "confetti"
@@ -122,6 +240,11 @@ const canvas = {
   height:100,
 
   offset: {x: 0, y:-100}, // height
+
+  fill: true, // false after `fill "none"`
+  stroke: true, // false after `stroke "none"`
+
+  saved: [], // states pushed by `save` and popped by `restore`
 }
 
 function initCanvas() {
@@ -141,6 +264,11 @@ function resetCanvas() {
   ctx.fillStyle = "black"
   ctx.strokeStyle = "black"
   ctx.lineWidth = 1
+  canvas.fill = true
+  canvas.stroke = true
+  canvas.saved = []
+  coordinates(0, 0, canvas.width, canvas.height)
+  textsize(6)
   move(0,0)
 }
 
@@ -183,8 +311,34 @@ function line(x2, y2) {
 
 function color(ptr, len) {
   const s = memString(ptr, len)
-  canvas.ctx.fillStyle = s;
-  canvas.ctx.strokeStyle = s;
+  setFill(s)
+  setStroke(s)
+}
+
+function stroke(ptr, len) {
+  setStroke(memString(ptr, len))
+}
+
+function fill(ptr, len) {
+  setFill(memString(ptr, len))
+}
+
+function setStroke(s) {
+  canvas.stroke = s !== 'none'
+  if (canvas.stroke) canvas.ctx.strokeStyle = s
+}
+
+function setFill(s) {
+  canvas.fill = s !== 'none'
+  if (canvas.fill) canvas.ctx.fillStyle = s
+}
+
+// fillAndStroke fills and outlines the current path, unless disabled
+// with `fill "none"` or `stroke "none"`.
+function fillAndStroke() {
+  const ctx = canvas.ctx
+  canvas.fill && ctx.fill()
+  canvas.stroke && ctx.stroke()
 }
 
 function width(n) {
@@ -195,7 +349,9 @@ function rect(dx, dy) {
   const {ctx, x, y} = canvas
   const sDX = scaleX(dx)
   const sDY = scaleY(dy)
-  canvas.ctx.fillRect(x, y, sDX, sDY)
+  ctx.beginPath()
+  ctx.rect(x, y, sDX, sDY)
+  fillAndStroke()
   movePhysical(x+sDX, y+sDY)
 }
 
@@ -203,7 +359,81 @@ function circle(r) {
   const { x, y, ctx } = canvas
   ctx.beginPath()
   ctx.arc(x, y, scaleX(r), 0, Math.PI * 2, true)
-  ctx.fill()
+  fillAndStroke()
+}
+
+// polygon draws a closed, filled and outlined shape through the
+// vertices read from wasm memory as n float64 values: x1 y1 x2 y2 ...
+function polygon(ptr, n) {
+  polyPath(ptr, n)
+  canvas.ctx.closePath()
+  fillAndStroke()
+}
+
+// polyline draws lines through the vertices, see polygon.
+function polyline(ptr, n) {
+  polyPath(ptr, n)
+  canvas.stroke && canvas.ctx.stroke()
+}
+
+function polyPath(ptr, n) {
+  const xy = new Float64Array(wasm.exports.memory.buffer, ptr, n)
+  const ctx = canvas.ctx
+  ctx.beginPath()
+  for (let i = 0; i + 1 < n; i += 2) {
+    ctx.lineTo(transformX(xy[i]), transformY(xy[i + 1]))
+  }
+}
+
+// curve draws a quadratic Bézier curve from the current position to
+// x, y with control point cx, cy.
+function curve(cx, cy, x2, y2) {
+  const { ctx, x, y } = canvas
+  const px2 = transformX(x2)
+  const py2 = transformY(y2)
+  ctx.beginPath()
+  ctx.moveTo(x, y)
+  ctx.quadraticCurveTo(transformX(cx), transformY(cy), px2, py2)
+  canvas.stroke && ctx.stroke()
+  movePhysical(px2, py2)
+}
+
+function text(ptr, len) {
+  const { ctx, x, y } = canvas
+  canvas.fill && ctx.fillText(memString(ptr, len), x, y)
+}
+
+function textsize(size) {
+  canvas.ctx.font = `${scaleX(size)}px sans-serif`
+}
+
+function clear() {
+  const ctx = canvas.ctx
+  ctx.clearRect(0, 0, ctx.canvas.width, ctx.canvas.height)
+}
+
+// save pushes the drawing state: colours, line width, font and
+// coordinate system, but not the pen position.
+function save() {
+  const { scale, offset, fill, stroke } = canvas
+  canvas.saved.push({ scale: { ...scale }, offset: { ...offset }, fill, stroke })
+  canvas.ctx.save()
+}
+
+// restore pops the drawing state pushed by save, if any.
+function restore() {
+  const state = canvas.saved.pop()
+  if (!state) return
+  Object.assign(canvas, state)
+  canvas.ctx.restore()
+}
+
+// coordinates sets the coordinate system so that the canvas spans
+// minx to maxx horizontally and miny to maxy vertically.
+function coordinates(minx, miny, maxx, maxy) {
+  const c = canvas.ctx.canvas
+  canvas.scale = { x: c.width / (maxx - minx), y: -c.height / (maxy - miny) }
+  canvas.offset = { x: -minx, y: -maxy }
 }
 
 
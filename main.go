@@ -3,18 +3,32 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"io"
+	"math/rand"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"foxygo.at/evy/pkg/evaluator"
 	"foxygo.at/evy/pkg/lexer"
 	"foxygo.at/evy/pkg/parser"
+	"foxygo.at/evy/pkg/raster"
+	"foxygo.at/evy/pkg/svg"
 	"github.com/alecthomas/kong"
 )
 
 var version string = "v0.0.0"
 
+// pngSize is the width and height of PNG drawings in pixels.
+const pngSize = 500
+
+// frameInterval is the time between two `on frame` events, 50 frames
+// per second.
+const frameInterval = 20 * time.Millisecond
+
 const description = `
 evy is a tool for managing evy source code.
 `
@@ -28,6 +42,39 @@ type config struct {
 
 type cmdRun struct {
 	Source string `arg:"" help:"Source file. Default stdin" default:"-"`
+	Seed   seed   `help:"Seed for random number generator. Default: time based" placeholder:"N"`
+	Input  string `help:"Input file for read, readln and key_press events. Default: stdin, if source is not stdin"`
+	SVG    string `help:"Write drawing of graphics builtins to SVG file" placeholder:"FILE" name:"svg" xor:"drawing"`
+	PNG    string `help:"Write drawing of graphics builtins to PNG file" placeholder:"FILE" name:"png" xor:"drawing"`
+	Frames int    `help:"Run exactly N frame events without waiting, e.g. to draw an animation to --svg or --png. Default: real time until exit" placeholder:"N"`
+}
+
+// seed is the --seed flag of cmdRun. It records whether it has been
+// set, so that 0 can be used as seed, too.
+type seed struct {
+	val int64
+	set bool
+}
+
+// Decode implements kong.MapperValue.
+func (s *seed) Decode(ctx *kong.DecodeContext) error {
+	var str string
+	if err := ctx.Scan.PopValueInto("seed", &str); err != nil {
+		return err
+	}
+	val, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid seed %q: %w", str, err)
+	}
+	*s = seed{val: val, set: true}
+	return nil
+}
+
+// drawing is a graphics backend that records the drawing of an evy
+// program for writing it to a file.
+type drawing interface {
+	Runtime() evaluator.GraphicsRuntime
+	io.WriterTo
 }
 
 type cmdTokenize struct {
@@ -44,8 +91,79 @@ func (c *cmdRun) Run() error {
 		return err
 	}
 	printFunc := func(s string) { fmt.Print(s) }
-	evaluator.Run(string(b), printFunc)
-	return nil
+	rt := evaluator.Runtime{Print: printFunc}
+	if c.Seed.set {
+		rt.Rand = rand.New(rand.NewSource(c.Seed.val))
+	}
+	in, closeFn, err := c.input()
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+	rt.Read = in.ReadLine
+	rt.Events = in
+	rt.Ticker = c.ticker()
+	d, filename := c.drawing()
+	if d == nil {
+		return evaluator.RunWithBuiltinsErr(string(b), evaluator.DefaultBuiltins(rt))
+	}
+	rt.Graphics = d.Runtime()
+	err = evaluator.RunWithBuiltinsErr(string(b), evaluator.DefaultBuiltins(rt))
+	// Write the drawing even after a runtime error to show its progress.
+	if writeErr := writeDrawing(filename, d); err == nil {
+		err = writeErr
+	}
+	return err
+}
+
+// ticker returns the ticker for `on frame`: headless for the --frames
+// flag, otherwise real time.
+func (c *cmdRun) ticker() evaluator.Ticker {
+	if c.Frames > 0 {
+		return evaluator.NewHeadlessTicker(c.Frames, frameInterval)
+	}
+	return evaluator.NewTicker(frameInterval)
+}
+
+// drawing returns the graphics backend for the --svg or --png flag and
+// its output filename, or nil if neither is given.
+func (c *cmdRun) drawing() (drawing, string) {
+	switch {
+	case c.SVG != "":
+		return svg.New(), c.SVG
+	case c.PNG != "":
+		return raster.New(pngSize), c.PNG
+	}
+	return nil, ""
+}
+
+func writeDrawing(filename string, d drawing) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if _, err := d.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// input returns the input of the evy program: the --input file if
+// given, otherwise stdin unless it is used for the evy source code.
+func (c *cmdRun) input() (*input, func(), error) {
+	noop := func() {}
+	if c.Input != "" {
+		f, err := os.Open(c.Input)
+		if err != nil {
+			return nil, noop, err
+		}
+		return newInput(f), func() { f.Close() }, nil
+	}
+	if c.Source != "-" {
+		return newInput(os.Stdin), noop, nil
+	}
+	return newInput(strings.NewReader("")), noop, nil
 }
 
 func (c *cmdTokenize) Run() error {
@@ -85,3 +203,116 @@ func fileBytes(filename string) ([]byte, error) {
 	}
 	return os.ReadFile(filename)
 }
+
+// input is the input of an evy program, shared by the read and readln
+// builtins and key_press events. It is an evaluator.EventSource, which
+// creates a key_press event for every character read, excluding
+// newlines.
+//
+// Once key_press events have started, the input is owned by a single
+// goroutine, see readKeys, and ReadLine requests are routed to it.
+type input struct {
+	r    *bufio.Reader
+	reqs chan chan lineResult // ReadLine requests to readKeys
+	keys chan struct{}        // closed when readKeys returns
+	done chan struct{}        // closed by Stop
+}
+
+type lineResult struct {
+	line string
+	err  error
+}
+
+func newInput(r io.Reader) *input {
+	keys := make(chan struct{})
+	close(keys) // readKeys not running
+	return &input{
+		r:    bufio.NewReader(r),
+		reqs: make(chan chan lineResult),
+		keys: keys,
+		done: make(chan struct{}),
+	}
+}
+
+// ReadLine reads the next line of input without trailing newline, as
+// required by evaluator.Runtime.Read. It must not be called
+// concurrently, which holds for the evaluator's builtins and event
+// handlers.
+func (in *input) ReadLine() (string, error) {
+	res := make(chan lineResult)
+	select {
+	case in.reqs <- res:
+		r := <-res
+		return r.line, r.err
+	case <-in.keys:
+		return in.readLine("")
+	}
+}
+
+// readLine reads the rest of the current line, prefixed with the
+// already read prefix.
+func (in *input) readLine(prefix string) (string, error) {
+	line, err := in.r.ReadString('\n')
+	line = prefix + line
+	if line == "" && err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\n")
+	return strings.TrimSuffix(line, "\r"), nil
+}
+
+func (in *input) Start(names []string) <-chan evaluator.Event {
+	ch := make(chan evaluator.Event)
+	if !contains(names, "key_press") {
+		close(ch)
+		return ch
+	}
+	in.keys = make(chan struct{})
+	go in.readKeys(ch)
+	return ch
+}
+
+func (in *input) Stop() {
+	close(in.done)
+}
+
+// readKeys reads the input character by character and sends a
+// key_press event for each to ch. A character that has been read but
+// not yet sent, because an event handler is still running, is handed
+// to a ReadLine request of that handler instead. This way no input is
+// lost or reordered, regardless of timing.
+func (in *input) readKeys(ch chan<- evaluator.Event) {
+	defer close(ch)
+	defer close(in.keys)
+	for {
+		c, _, err := in.r.ReadRune()
+		if err != nil {
+			return
+		}
+		if c == '\n' {
+			continue
+		}
+		select {
+		case ch <- keyPressEvent(c):
+		case res := <-in.reqs:
+			line, err := in.readLine(string(c))
+			res <- lineResult{line: line, err: err}
+		case <-in.done:
+			return
+		}
+	}
+}
+
+func keyPressEvent(c rune) evaluator.Event {
+	key := &evaluator.String{Val: string(c)}
+	return evaluator.Event{Name: "key_press", Params: []evaluator.Value{key}}
+}
+
+func contains(strs []string, s string) bool {
+	for _, str := range strs {
+		if str == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,35 @@
+//go:build !tinygo
+
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"foxygo.at/evy/pkg/assert"
+)
+
+func TestInputReadLineInKeyPress(t *testing.T) {
+	in := newInput(strings.NewReader("ab\ncd\nef"))
+	line, err := in.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "ab", line)
+
+	ch := in.Start([]string{"key_press"})
+	var got []string
+	for ev := range ch {
+		key := ev.Params[0].String()
+		got = append(got, key)
+		if key == "c" {
+			// read in key_press handler gets the rest of the line
+			line, err := in.ReadLine()
+			assert.NoError(t, err)
+			got = append(got, "readln:"+line)
+		}
+	}
+	assert.Equal(t, []string{"c", "readln:d", "e", "f"}, got)
+
+	_, err = in.ReadLine()
+	assert.Equal(t, io.EOF, err)
+}
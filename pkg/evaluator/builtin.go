@@ -1,8 +1,13 @@
 package evaluator
 
 import (
+	"math"
+	"math/rand"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"foxygo.at/evy/pkg/parser"
 )
@@ -10,53 +15,173 @@ import (
 type Builtin struct {
 	Func BuiltinFunc
 	Decl *parser.FuncDecl
+	// Recoverable is set for builtins that can fail with a recoverable
+	// error. Only calls to them set or reset `error` and `errnum`.
+	Recoverable bool
 }
 
 type Builtins struct {
-	Funcs map[string]Builtin
-	Print func(s string)
+	Funcs   map[string]Builtin
+	Globals map[string]Value
+	Print   func(s string)
+	Events  EventSource
+	Ticker  Ticker
 }
 
-func (b Builtins) Decls() map[string]*parser.FuncDecl {
-	decls := make(map[string]*parser.FuncDecl, len(b.Funcs))
+func (b Builtins) Decls() parser.Builtins {
+	funcs := make(map[string]*parser.FuncDecl, len(b.Funcs))
 	for name, builtin := range b.Funcs {
-		decls[name] = builtin.Decl
+		funcs[name] = builtin.Decl
 	}
-	return decls
+	globals := make(map[string]*parser.Var, len(b.Globals))
+	for name, val := range b.Globals {
+		globals[name] = &parser.Var{Name: name, T: valueType(val)}
+	}
+	return parser.Builtins{Funcs: funcs, Globals: globals}
 }
 
-type BuiltinFunc func(args []Value) Value
+type BuiltinFunc func(args []Value) (Value, error)
 
 func (b BuiltinFunc) Type() ValueType { return BUILTIN }
 func (b BuiltinFunc) String() string  { return "builtin function" }
 
 func DefaultBuiltins(rt Runtime) Builtins {
+	if rt.Rand == nil {
+		rt.Rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	if rt.Clock == nil {
+		rt.Clock = realClock{}
+	}
+	lines := &lineReader{readLine: rt.Read}
 	funcs := map[string]Builtin{
-		"print":  {Func: printFunc(rt.Print), Decl: printDecl},
-		"sprint": {Func: sprintFunc, Decl: sprintDecl},
-		"join":   {Func: joinFunc, Decl: joinDecl},
-		"split":  {Func: splitFunc, Decl: splitDecl},
+		"print":   {Func: printFunc(rt.Print, sprint, "\n"), Decl: printDecl("print")},
+		"prints":  {Func: printFunc(rt.Print, sprints, ""), Decl: printDecl("prints")},
+		"printq":  {Func: printFunc(rt.Print, sprintq, "\n"), Decl: printDecl("printq")},
+		"sprint":  {Func: sprintFunc(sprint), Decl: sprintDecl("sprint")},
+		"sprints": {Func: sprintFunc(sprints), Decl: sprintDecl("sprints")},
+		"sprintq": {Func: sprintFunc(sprintq), Decl: sprintDecl("sprintq")},
+		"join":    {Func: joinFunc, Decl: joinDecl},
+		"split":   {Func: splitFunc, Decl: splitDecl},
+		"fields":  {Func: fieldsFunc, Decl: fieldsDecl},
+
+		"upper":      strBuiltin("upper", strings.ToUpper),
+		"lower":      strBuiltin("lower", strings.ToLower),
+		"trim":       {Func: BuiltinFunc(trimFunc), Decl: trimDecl},
+		"index":      {Func: BuiltinFunc(indexFunc), Decl: indexDecl},
+		"replace":    {Func: BuiltinFunc(replaceFunc), Decl: replaceDecl},
+		"startswith": strPredicateBuiltin("startswith", "prefix", strings.HasPrefix),
+		"endswith":   strPredicateBuiltin("endswith", "suffix", strings.HasSuffix),
+		"repeat":     {Func: BuiltinFunc(repeatFunc), Decl: repeatDecl},
 
 		"len": {Func: BuiltinFunc(lenFunc), Decl: lenDecl},
 		"has": {Func: BuiltinFunc(hasFunc), Decl: hasDecl},
 		"del": {Func: BuiltinFunc(delFunc), Decl: delDecl},
 
+		"append":  {Func: BuiltinFunc(appendFunc), Decl: appendDecl},
+		"prepend": {Func: BuiltinFunc(prependFunc), Decl: prependDecl},
+
+		"str2num":  {Func: BuiltinFunc(str2numFunc), Decl: str2numDecl, Recoverable: true},
+		"str2bool": {Func: BuiltinFunc(str2boolFunc), Decl: str2boolDecl, Recoverable: true},
+		"num2str":  {Func: BuiltinFunc(num2strFunc), Decl: num2strDecl},
+		"bool2str": {Func: BuiltinFunc(bool2strFunc), Decl: bool2strDecl},
+
+		"reflect": {Func: BuiltinFunc(reflectFunc), Decl: reflectDecl},
+		"panic":   {Func: BuiltinFunc(panicFunc), Decl: panicDecl},
+		"exit":    {Func: BuiltinFunc(exitFunc), Decl: exitDecl},
+
+		"div":   mathBuiltin2("div", div),
+		"pow":   mathBuiltin2("pow", math.Pow),
+		"sqrt":  mathBuiltin1("sqrt", math.Sqrt),
+		"logn":  mathBuiltin1("logn", math.Log),
+		"sin":   mathBuiltin1("sin", math.Sin),
+		"asin":  mathBuiltin1("asin", math.Asin),
+		"cos":   mathBuiltin1("cos", math.Cos),
+		"acos":  mathBuiltin1("acos", math.Acos),
+		"tan":   mathBuiltin1("tan", math.Tan),
+		"atan":  mathBuiltin1("atan", math.Atan),
+		"atan2": mathBuiltin2("atan2", math.Atan2),
+		"abs":   mathBuiltin1("abs", math.Abs),
+		"floor": mathBuiltin1("floor", math.Floor),
+
+		"random":  {Func: randomFunc(rt.Rand), Decl: randomDecl},
+		"randomf": {Func: randomfFunc(rt.Rand), Decl: randomfDecl},
+
+		"now":          {Func: nowFunc(rt.Clock), Decl: nowDecl},
+		"format_time":  {Func: BuiltinFunc(formatTimeFunc), Decl: formatTimeDecl},
+		"format_timef": {Func: BuiltinFunc(formatTimefFunc), Decl: formatTimefDecl},
+		"parse_time":   {Func: BuiltinFunc(parseTimeFunc), Decl: parseTimeDecl, Recoverable: true},
+		"parse_timef":  {Func: BuiltinFunc(parseTimefFunc), Decl: parseTimefDecl, Recoverable: true},
+		"sleep":        {Func: sleepFunc(rt.Clock), Decl: sleepDecl},
+
+		"read":   inputBuiltin(readDecl, lines.word, rt.Read, rt.Print),
+		"readln": inputBuiltin(readlnDecl, lines.line, rt.Read, rt.Print),
+		"readid": readidBuiltin(rt.ReadID, rt.Print),
+
 		"move":   xyBuiltin("move", rt.Graphics.Move, rt.Print),
 		"line":   xyBuiltin("line", rt.Graphics.Line, rt.Print),
 		"rect":   xyBuiltin("rect", rt.Graphics.Rect, rt.Print),
 		"circle": numBuiltin("circle", rt.Graphics.Circle, rt.Print),
 		"width":  numBuiltin("width", rt.Graphics.Width, rt.Print),
-		"color":  stringBuiltin("color", rt.Graphics.Color, rt.Print),
-		"colour": stringBuiltin("colour", rt.Graphics.Color, rt.Print),
+		"color":  colorBuiltin("color", rt.Graphics.Color, rt.Print),
+		"colour": colorBuiltin("colour", rt.Graphics.Color, rt.Print),
+		"stroke": colorBuiltin("stroke", rt.Graphics.Stroke, rt.Print),
+		"fill":   colorBuiltin("fill", rt.Graphics.Fill, rt.Print),
+
+		"polygon":  pointsBuiltin("polygon", rt.Graphics.Polygon, rt.Print),
+		"polyline": pointsBuiltin("polyline", rt.Graphics.Polyline, rt.Print),
+		"curve":    curveBuiltin(rt.Graphics.Curve, rt.Print),
+		"text":     stringBuiltin("text", rt.Graphics.Text, rt.Print),
+		"textsize": numBuiltin("textsize", rt.Graphics.TextSize, rt.Print),
+
+		"clear":       noArgBuiltin("clear", rt.Graphics.Clear, rt.Print),
+		"save":        noArgBuiltin("save", rt.Graphics.Save, rt.Print),
+		"restore":     noArgBuiltin("restore", rt.Graphics.Restore, rt.Print),
+		"coordinates": coordinatesBuiltin(rt.Graphics.Coordinates, rt.Print),
+	}
+	globals := map[string]Value{
+		"error":  &String{},
+		"errnum": &Num{},
+		"pi":     &Num{Val: math.Pi},
 	}
-	return Builtins{Funcs: funcs, Print: rt.Print}
+	return Builtins{Funcs: funcs, Globals: globals, Print: rt.Print, Events: rt.Events, Ticker: rt.Ticker}
 }
 
 type Runtime struct {
 	Print    func(string)
 	Graphics GraphicsRuntime
+	Events   EventSource // optional, event handlers are not called if nil
+	Ticker   Ticker      // optional, `on frame` is not called if nil
+	Rand     *rand.Rand  // optional, seeded with the current time if nil
+	Clock    Clock       // optional, real time if nil
+
+	// Read reads the next line of input without the trailing newline,
+	// returning io.EOF at the end of input. It is used by the read and
+	// readln builtins.
+	Read func() (string, error)
+	// ReadID reads the value of the element with the given query
+	// selector, e.g. a text input field in the browser.
+	ReadID func(selector string) string
+}
+
+// Clock provides the current time and sleeping to the time builtins,
+// so that tests can use a fake clock that advances instantly.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
 }
 
+type realClock struct{}
+
+func (realClock) Now() time.Time        { return time.Now() }
+func (realClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// GraphicsRuntime holds the drawing primitives of the graphics
+// builtins. Unset primitives print a "not yet implemented" message.
+//
+// Rect, Circle and Polygon are filled with the fill colour and outlined
+// with the stroke colour. Color sets both, Stroke and Fill set them
+// individually. Colours are validated and normalised to #rrggbb or
+// #rrggbbaa; the colour "none" disables filling or outlining.
 type GraphicsRuntime struct {
 	Move   func(x, y float64)
 	Line   func(x, y float64)
@@ -64,29 +189,84 @@ type GraphicsRuntime struct {
 	Circle func(radius float64)
 	Width  func(w float64)
 	Color  func(s string)
+	Stroke func(s string)
+	Fill   func(s string)
+
+	// Polygon and Polyline take a list of [x y] vertices. Polygon is
+	// closed, Polyline is stroked only.
+	Polygon  func(vertices [][]float64)
+	Polyline func(vertices [][]float64)
+	// Curve draws a quadratic Bézier curve from the current position
+	// to (x, y) with control point (cx, cy) and moves to (x, y).
+	Curve func(cx, cy, x, y float64)
+	// Text writes s at the current position in the fill colour, with
+	// TextSize setting the font size.
+	Text     func(s string)
+	TextSize func(size float64)
+
+	// Clear clears the drawing area, keeping the drawing state.
+	Clear func()
+	// Save pushes the drawing state, that is the colours, line width,
+	// text size and coordinate system, onto a stack; Restore pops it.
+	// The current position is not part of the drawing state.
+	Save    func()
+	Restore func()
+	// Coordinates sets the coordinate system so that the drawing area
+	// spans minX to maxX horizontally and minY to maxY vertically,
+	// with the default 0 0 100 100. Line width and text size keep
+	// their size on screen.
+	Coordinates func(minX, minY, maxX, maxY float64)
 }
 
-var printDecl = &parser.FuncDecl{
-	Name:          "print",
-	VariadicParam: &parser.Var{Name: "a", T: parser.ANY_TYPE},
-	ReturnType:    parser.NONE_TYPE,
+func printDecl(name string) *parser.FuncDecl {
+	return &parser.FuncDecl{
+		Name:          name,
+		VariadicParam: &parser.Var{Name: "a", T: parser.ANY_TYPE},
+		ReturnType:    parser.NONE_TYPE,
+	}
+}
+
+// printFunc returns a builtin that prints its arguments formatted with
+// format, followed by end.
+func printFunc(printFn func(string), format func([]Value) string, end string) BuiltinFunc {
+	return func(args []Value) (Value, error) {
+		printFn(format(args) + end)
+		return nil, nil
+	}
 }
 
-func printFunc(printFn func(string)) BuiltinFunc {
-	return func(args []Value) Value {
-		printFn(join(args, " ") + "\n")
-		return nil
+func sprintDecl(name string) *parser.FuncDecl {
+	return &parser.FuncDecl{
+		Name:          name,
+		VariadicParam: &parser.Var{Name: "a", T: parser.ANY_TYPE},
+		ReturnType:    parser.STRING_TYPE,
 	}
 }
 
-var sprintDecl = &parser.FuncDecl{
-	Name:          "sprint",
-	VariadicParam: &parser.Var{Name: "a", T: parser.ANY_TYPE},
-	ReturnType:    parser.STRING_TYPE,
+func sprintFunc(format func([]Value) string) BuiltinFunc {
+	return func(args []Value) (Value, error) {
+		return &String{Val: format(args)}, nil
+	}
+}
+
+// sprint formats args separated by spaces.
+func sprint(args []Value) string {
+	return join(args, " ")
+}
+
+// sprints formats args without separator.
+func sprints(args []Value) string {
+	return join(args, "")
 }
 
-func sprintFunc(args []Value) Value {
-	return &String{Val: join(args, " ")}
+// sprintq formats args as evy literals separated by spaces, so that
+// strings are quoted.
+func sprintq(args []Value) string {
+	literals := make([]string, len(args))
+	for i, arg := range args {
+		literals[i] = arg.Literal()
+	}
+	return strings.Join(literals, " ")
 }
 
 var joinDecl = &parser.FuncDecl{
@@ -98,11 +278,11 @@ var joinDecl = &parser.FuncDecl{
 	ReturnType: parser.STRING_TYPE,
 }
 
-func joinFunc(args []Value) Value {
+func joinFunc(args []Value) (Value, error) {
 	arr := args[0].(*Array)
 	sep := args[1].(*String)
 	s := join(*arr.Elements, sep.Val)
-	return &String{Val: s}
+	return &String{Val: s}, nil
 }
 
 func join(args []Value, sep string) string {
@@ -127,36 +307,186 @@ var splitDecl = &parser.FuncDecl{
 	ReturnType: stringArrayType,
 }
 
-func splitFunc(args []Value) Value {
+// splitFunc splits s into all substrings separated by sep. If sep is
+// empty, s is split into its characters (Unicode code points).
+func splitFunc(args []Value) (Value, error) {
 	s := args[0].(*String)
 	sep := args[1].(*String)
-	slice := strings.Split(s.Val, sep.Val)
-	elements := make([]Value, len(slice))
-	for i, s := range slice {
+	if sep.Val == "" {
+		runes := s.runes()
+		elements := make([]Value, len(runes))
+		for i, r := range runes {
+			elements[i] = &String{Val: string(r)}
+		}
+		return &Array{Elements: &elements}, nil
+	}
+	return stringArray(strings.Split(s.Val, sep.Val)), nil
+}
+
+var fieldsDecl = &parser.FuncDecl{
+	Name:       "fields",
+	Params:     []*parser.Var{{Name: "s", T: parser.STRING_TYPE}},
+	ReturnType: stringArrayType,
+}
+
+// fieldsFunc splits s around each run of whitespace characters,
+// dropping leading and trailing whitespace.
+func fieldsFunc(args []Value) (Value, error) {
+	s := args[0].(*String)
+	return stringArray(strings.Fields(s.Val)), nil
+}
+
+func stringArray(strs []string) *Array {
+	elements := make([]Value, len(strs))
+	for i, s := range strs {
 		elements[i] = &String{Val: s}
 	}
 	return &Array{Elements: &elements}
 }
 
+// strBuiltin creates a builtin for a string function with a single
+// string parameter, e.g. upper.
+func strBuiltin(name string, fn func(string) string) Builtin {
+	decl := &parser.FuncDecl{
+		Name:       name,
+		Params:     []*parser.Var{{Name: "s", T: parser.STRING_TYPE}},
+		ReturnType: parser.STRING_TYPE,
+	}
+	f := func(args []Value) (Value, error) {
+		s := args[0].(*String)
+		return &String{Val: fn(s.Val)}, nil
+	}
+	return Builtin{Func: f, Decl: decl}
+}
+
+// strPredicateBuiltin creates a builtin for a string function with
+// two string parameters returning bool, e.g. startswith.
+func strPredicateBuiltin(name, paramName string, fn func(string, string) bool) Builtin {
+	decl := &parser.FuncDecl{
+		Name: name,
+		Params: []*parser.Var{
+			{Name: "s", T: parser.STRING_TYPE},
+			{Name: paramName, T: parser.STRING_TYPE},
+		},
+		ReturnType: parser.BOOL_TYPE,
+	}
+	f := func(args []Value) (Value, error) {
+		s := args[0].(*String)
+		s2 := args[1].(*String)
+		return &Bool{Val: fn(s.Val, s2.Val)}, nil
+	}
+	return Builtin{Func: f, Decl: decl}
+}
+
+var trimDecl = &parser.FuncDecl{
+	Name: "trim",
+	Params: []*parser.Var{
+		{Name: "s", T: parser.STRING_TYPE},
+		{Name: "cutset", T: parser.STRING_TYPE},
+	},
+	ReturnType: parser.STRING_TYPE,
+}
+
+// trimFunc removes all leading and trailing characters contained in
+// cutset from s.
+func trimFunc(args []Value) (Value, error) {
+	s := args[0].(*String)
+	cutset := args[1].(*String)
+	return &String{Val: strings.Trim(s.Val, cutset.Val)}, nil
+}
+
+var indexDecl = &parser.FuncDecl{
+	Name: "index",
+	Params: []*parser.Var{
+		{Name: "s", T: parser.STRING_TYPE},
+		{Name: "substr", T: parser.STRING_TYPE},
+	},
+	ReturnType: parser.NUM_TYPE,
+}
+
+// indexFunc returns the character index of the first occurrence of
+// substr in s, or -1 if substr is not contained in s. The index counts
+// Unicode code points, consistent with string indexing.
+func indexFunc(args []Value) (Value, error) {
+	s := args[0].(*String)
+	substr := args[1].(*String)
+	i := strings.Index(s.Val, substr.Val)
+	if i > 0 {
+		i = utf8.RuneCountInString(s.Val[:i])
+	}
+	return &Num{Val: float64(i)}, nil
+}
+
+var replaceDecl = &parser.FuncDecl{
+	Name: "replace",
+	Params: []*parser.Var{
+		{Name: "s", T: parser.STRING_TYPE},
+		{Name: "old", T: parser.STRING_TYPE},
+		{Name: "new", T: parser.STRING_TYPE},
+	},
+	ReturnType: parser.STRING_TYPE,
+}
+
+// replaceFunc replaces all occurrences of old in s with new.
+func replaceFunc(args []Value) (Value, error) {
+	s := args[0].(*String)
+	oldStr := args[1].(*String)
+	newStr := args[2].(*String)
+	return &String{Val: strings.ReplaceAll(s.Val, oldStr.Val, newStr.Val)}, nil
+}
+
+var repeatDecl = &parser.FuncDecl{
+	Name: "repeat",
+	Params: []*parser.Var{
+		{Name: "s", T: parser.STRING_TYPE},
+		{Name: "n", T: parser.NUM_TYPE},
+	},
+	ReturnType: parser.STRING_TYPE,
+}
+
+// maxRepeatLen is the maximum length in bytes of the string returned
+// by repeat.
+const maxRepeatLen = 1 << 24
+
+// repeatFunc returns s repeated n times. n must be a whole number that
+// does not make the result longer than maxRepeatLen.
+func repeatFunc(args []Value) (Value, error) {
+	s := args[0].(*String).Val
+	n := args[1].(*Num).Val
+	switch {
+	case n < 0:
+		return nil, newError(ErrRange, "repeat: n must not be negative, found "+args[1].String())
+	case n != math.Trunc(n):
+		return nil, newError(ErrRange, "repeat: n must be a whole number, found "+args[1].String())
+	case s == "":
+		return &String{}, nil
+	case n > float64(maxRepeatLen/len(s)):
+		return nil, newError(ErrRange, "repeat: result must not be longer than "+strconv.Itoa(maxRepeatLen)+" bytes, found n "+args[1].String())
+	}
+	return &String{Val: strings.Repeat(s, int(n))}, nil
+}
+
 var lenDecl = &parser.FuncDecl{
 	Name:       "len",
 	Params:     []*parser.Var{{Name: "a", T: parser.ANY_TYPE}},
 	ReturnType: parser.NUM_TYPE,
 }
 
-func lenFunc(args []Value) Value {
+// lenFunc returns the number of elements of an array or map, or the
+// number of Unicode code points of a string.
+func lenFunc(args []Value) (Value, error) {
 	if len(args) != 1 {
-		return newError("'len' takes 1 argument not " + strconv.Itoa(len(args)))
+		return nil, newError(ErrInternal, "'len' takes 1 argument not "+strconv.Itoa(len(args)))
 	}
 	switch arg := args[0].(type) {
 	case *Map:
-		return &Num{Val: float64(len(arg.Pairs))}
+		return &Num{Val: float64(len(arg.Pairs))}, nil
 	case *Array:
-		return &Num{Val: float64(len(*arg.Elements))}
+		return &Num{Val: float64(len(*arg.Elements))}, nil
 	case *String:
-		return &Num{Val: float64(len(arg.Val))}
+		return &Num{Val: float64(len(arg.runes()))}, nil
 	}
-	return newError("'len' takes 1 argument of type 'string', array '[]' or map '{}' not " + args[0].Type().String())
+	return nil, newError(ErrInternal, "'len' takes 1 argument of type 'string', array '[]' or map '{}' not "+args[0].Type().String())
 }
 
 var hasDecl = &parser.FuncDecl{
@@ -168,11 +498,11 @@ var hasDecl = &parser.FuncDecl{
 	ReturnType: parser.BOOL_TYPE,
 }
 
-func hasFunc(args []Value) Value {
+func hasFunc(args []Value) (Value, error) {
 	m := args[0].(*Map)
 	key := args[1].(*String)
 	_, ok := m.Pairs[key.Val]
-	return &Bool{Val: ok}
+	return &Bool{Val: ok}, nil
 }
 
 var delDecl = &parser.FuncDecl{
@@ -184,11 +514,183 @@ var delDecl = &parser.FuncDecl{
 	ReturnType: parser.NONE_TYPE,
 }
 
-func delFunc(args []Value) Value {
+func delFunc(args []Value) (Value, error) {
 	m := args[0].(*Map)
 	keyStr := args[1].(*String)
 	m.Delete(keyStr.Val)
-	return nil
+	return nil, nil
+}
+
+var appendDecl = &parser.FuncDecl{
+	Name: "append",
+	Params: []*parser.Var{
+		{Name: "arr", T: parser.GENERIC_ARRAY},
+		{Name: "val", T: parser.GENERIC_ELEMENT},
+	},
+	ReturnType: parser.NONE_TYPE,
+}
+
+// appendFunc adds val to the end of arr in place, so that all
+// references to arr see the new element.
+func appendFunc(args []Value) (Value, error) {
+	arr := args[0].(*Array)
+	*arr.Elements = append(*arr.Elements, copyOrRef(args[1]))
+	return nil, nil
+}
+
+var prependDecl = &parser.FuncDecl{
+	Name: "prepend",
+	Params: []*parser.Var{
+		{Name: "arr", T: parser.GENERIC_ARRAY},
+		{Name: "val", T: parser.GENERIC_ELEMENT},
+	},
+	ReturnType: parser.NONE_TYPE,
+}
+
+// prependFunc adds val to the beginning of arr in place, so that all
+// references to arr see the new element.
+func prependFunc(args []Value) (Value, error) {
+	arr := args[0].(*Array)
+	elements := append([]Value{copyOrRef(args[1])}, *arr.Elements...)
+	*arr.Elements = elements
+	return nil, nil
+}
+
+var str2numDecl = &parser.FuncDecl{
+	Name:       "str2num",
+	Params:     []*parser.Var{{Name: "s", T: parser.STRING_TYPE}},
+	ReturnType: parser.NUM_TYPE,
+}
+
+// str2numFunc parses numbers as formatted by Num.String, e.g. "-1.5",
+// as well as exponents, e.g. "1e3". Other formats accepted by
+// strconv.ParseFloat, such as "inf", "NaN", "1_0" or "0x1p4", are
+// rejected. On failure it returns 0 and a recoverable conversion error.
+func str2numFunc(args []Value) (Value, error) {
+	s := args[0].(*String)
+	n, err := strconv.ParseFloat(s.Val, 64)
+	if err != nil || strings.Trim(s.Val, "0123456789.eE+-") != "" {
+		return &Num{}, newRecoverableError(ErrConversion, "str2num: cannot parse "+strconv.Quote(s.Val))
+	}
+	return &Num{Val: n}, nil
+}
+
+var str2boolDecl = &parser.FuncDecl{
+	Name:       "str2bool",
+	Params:     []*parser.Var{{Name: "s", T: parser.STRING_TYPE}},
+	ReturnType: parser.BOOL_TYPE,
+}
+
+// str2boolFunc parses "true" and "false" only, as formatted by
+// Bool.String. On failure it returns false and a recoverable
+// conversion error.
+func str2boolFunc(args []Value) (Value, error) {
+	s := args[0].(*String)
+	switch s.Val {
+	case "true":
+		return &Bool{Val: true}, nil
+	case "false":
+		return &Bool{Val: false}, nil
+	}
+	return &Bool{}, newRecoverableError(ErrConversion, "str2bool: cannot parse "+strconv.Quote(s.Val))
+}
+
+var num2strDecl = &parser.FuncDecl{
+	Name:       "num2str",
+	Params:     []*parser.Var{{Name: "n", T: parser.NUM_TYPE}},
+	ReturnType: parser.STRING_TYPE,
+}
+
+func num2strFunc(args []Value) (Value, error) {
+	return &String{Val: args[0].String()}, nil
+}
+
+var bool2strDecl = &parser.FuncDecl{
+	Name:       "bool2str",
+	Params:     []*parser.Var{{Name: "b", T: parser.BOOL_TYPE}},
+	ReturnType: parser.STRING_TYPE,
+}
+
+func bool2strFunc(args []Value) (Value, error) {
+	return &String{Val: args[0].String()}, nil
+}
+
+var reflectDecl = &parser.FuncDecl{
+	Name:       "reflect",
+	Params:     []*parser.Var{{Name: "a", T: parser.ANY_TYPE}},
+	ReturnType: &parser.Type{Name: parser.MAP, Sub: parser.ANY_TYPE},
+}
+
+func reflectFunc(args []Value) (Value, error) {
+	return reflect(args[0]), nil
+}
+
+// reflect returns a map describing the type of val, e.g. {type:"num"}
+// or {type:"array" sub:{type:"string"}}. The sub type of arrays and maps
+// is inferred from their elements. It is "any" for empty arrays and
+// maps and if the elements have different types.
+func reflect(val Value) *Map {
+	switch val := unwrapAny(val).(type) {
+	case *Array:
+		return reflectComposite("array", *val.Elements)
+	case *Map:
+		elements := make([]Value, 0, len(val.Pairs))
+		for _, key := range *val.Order {
+			elements = append(elements, val.Pairs[key])
+		}
+		return reflectComposite("map", elements)
+	default:
+		return reflectMap(val.Type().String(), nil)
+	}
+}
+
+func reflectComposite(typeName string, elements []Value) *Map {
+	var sub *Map
+	for _, elem := range elements {
+		elemType := reflect(elem)
+		if sub == nil {
+			sub = elemType
+		} else if !sub.Equals(elemType) {
+			sub = nil
+			break
+		}
+	}
+	if sub == nil {
+		sub = reflectMap("any", nil)
+	}
+	return reflectMap(typeName, sub)
+}
+
+// reflectMap returns a {}any map, so its values are wrapped in *Any.
+func reflectMap(typeName string, sub *Map) *Map {
+	pairs := map[string]Value{"type": wrapAny(&String{Val: typeName}, parser.ANY_TYPE)}
+	order := []string{"type"}
+	if sub != nil {
+		pairs["sub"] = wrapAny(sub, parser.ANY_TYPE)
+		order = append(order, "sub")
+	}
+	return &Map{Pairs: pairs, Order: &order}
+}
+
+var panicDecl = &parser.FuncDecl{
+	Name:       "panic",
+	Params:     []*parser.Var{{Name: "s", T: parser.STRING_TYPE}},
+	ReturnType: parser.NONE_TYPE,
+}
+
+func panicFunc(args []Value) (Value, error) {
+	return nil, newError(ErrPanic, args[0].(*String).Val)
+}
+
+var exitDecl = &parser.FuncDecl{
+	Name:       "exit",
+	ReturnType: parser.NONE_TYPE,
+}
+
+// exitFunc stops the evy program with an ErrExit error, which is not
+// reported as failure, see IsExit.
+func exitFunc(_ []Value) (Value, error) {
+	return nil, newError(ErrExit, "exit")
 }
 
 func xyDecl(name string) *parser.FuncDecl {
@@ -205,14 +707,14 @@ func xyDecl(name string) *parser.FuncDecl {
 func xyBuiltin(name string, fn func(x, y float64), printFn func(string)) Builtin {
 	result := Builtin{Decl: xyDecl(name)}
 	if fn == nil {
-		result.Func = notImplementedFunc(name, printFn)
+		result.Func = notImplementedFunc(result.Decl, printFn)
 		return result
 	}
-	result.Func = func(args []Value) Value {
+	result.Func = func(args []Value) (Value, error) {
 		x := args[0].(*Num)
 		y := args[1].(*Num)
 		fn(x.Val, y.Val)
-		return nil
+		return nil, nil
 	}
 	return result
 }
@@ -230,17 +732,435 @@ func numDecl(name string) *parser.FuncDecl {
 func numBuiltin(name string, fn func(n float64), printFn func(string)) Builtin {
 	result := Builtin{Decl: numDecl(name)}
 	if fn == nil {
-		result.Func = notImplementedFunc(name, printFn)
+		result.Func = notImplementedFunc(result.Decl, printFn)
 		return result
 	}
-	result.Func = func(args []Value) Value {
+	result.Func = func(args []Value) (Value, error) {
 		n := args[0].(*Num)
 		fn(n.Val)
-		return nil
+		return nil, nil
 	}
 	return result
 }
 
+func colorDecl(name string) *parser.FuncDecl {
+	return &parser.FuncDecl{
+		Name:       name,
+		Params:     []*parser.Var{{Name: "c", T: parser.STRING_OR_NUM}},
+		ReturnType: parser.NONE_TYPE,
+	}
+}
+
+// colorBuiltin creates a builtin taking a CSS colour string, e.g.
+// "red" or "#ff0000", or a number in the rgb shorthand, e.g. 900. fn
+// is called with the colour normalised to #rrggbb or #rrggbbaa.
+func colorBuiltin(name string, fn func(s string), printFn func(string)) Builtin {
+	result := Builtin{Decl: colorDecl(name)}
+	if fn == nil {
+		result.Func = notImplementedFunc(result.Decl, printFn)
+		return result
+	}
+	result.Func = func(args []Value) (Value, error) {
+		var c string
+		var ok bool
+		switch arg := args[0].(type) {
+		case *String:
+			c, ok = parseColor(arg.Val)
+		case *Num:
+			c, ok = numColor(arg.Val)
+		}
+		if !ok {
+			return nil, newError(ErrConversion, name+": invalid color "+args[0].Literal())
+		}
+		fn(c)
+		return nil, nil
+	}
+	return result
+}
+
+var numArrayType = &parser.Type{
+	Name: parser.ARRAY,
+	Sub:  parser.NUM_TYPE,
+}
+
+func pointsDecl(name string) *parser.FuncDecl {
+	return &parser.FuncDecl{
+		Name:          name,
+		VariadicParam: &parser.Var{Name: "points", T: numArrayType},
+		ReturnType:    parser.NONE_TYPE,
+	}
+}
+
+// pointsBuiltin creates a builtin that takes a variable number of
+// [x y] points, e.g. `polygon [0 0] [10 0] [10 10]`.
+func pointsBuiltin(name string, fn func(vertices [][]float64), printFn func(string)) Builtin {
+	result := Builtin{Decl: pointsDecl(name)}
+	if fn == nil {
+		result.Func = notImplementedFunc(result.Decl, printFn)
+		return result
+	}
+	result.Func = func(args []Value) (Value, error) {
+		vertices := make([][]float64, len(args))
+		for i, arg := range args {
+			elements := *arg.(*Array).Elements
+			if len(elements) != 2 {
+				return nil, newError(ErrRange, name+": points must have 2 coordinates [x y], found "+arg.String())
+			}
+			vertices[i] = []float64{elements[0].(*Num).Val, elements[1].(*Num).Val}
+		}
+		fn(vertices)
+		return nil, nil
+	}
+	return result
+}
+
+var curveDecl = &parser.FuncDecl{
+	Name: "curve",
+	Params: []*parser.Var{
+		{Name: "cx", T: parser.NUM_TYPE},
+		{Name: "cy", T: parser.NUM_TYPE},
+		{Name: "x", T: parser.NUM_TYPE},
+		{Name: "y", T: parser.NUM_TYPE},
+	},
+	ReturnType: parser.NONE_TYPE,
+}
+
+func curveBuiltin(fn func(cx, cy, x, y float64), printFn func(string)) Builtin {
+	result := Builtin{Decl: curveDecl}
+	if fn == nil {
+		result.Func = notImplementedFunc(result.Decl, printFn)
+		return result
+	}
+	result.Func = func(args []Value) (Value, error) {
+		cx := args[0].(*Num)
+		cy := args[1].(*Num)
+		x := args[2].(*Num)
+		y := args[3].(*Num)
+		fn(cx.Val, cy.Val, x.Val, y.Val)
+		return nil, nil
+	}
+	return result
+}
+
+func noArgDecl(name string) *parser.FuncDecl {
+	return &parser.FuncDecl{
+		Name:       name,
+		ReturnType: parser.NONE_TYPE,
+	}
+}
+
+func noArgBuiltin(name string, fn func(), printFn func(string)) Builtin {
+	result := Builtin{Decl: noArgDecl(name)}
+	if fn == nil {
+		result.Func = notImplementedFunc(result.Decl, printFn)
+		return result
+	}
+	result.Func = func(args []Value) (Value, error) {
+		fn()
+		return nil, nil
+	}
+	return result
+}
+
+var coordinatesDecl = &parser.FuncDecl{
+	Name: "coordinates",
+	Params: []*parser.Var{
+		{Name: "minx", T: parser.NUM_TYPE},
+		{Name: "miny", T: parser.NUM_TYPE},
+		{Name: "maxx", T: parser.NUM_TYPE},
+		{Name: "maxy", T: parser.NUM_TYPE},
+	},
+	ReturnType: parser.NONE_TYPE,
+}
+
+func coordinatesBuiltin(fn func(minX, minY, maxX, maxY float64), printFn func(string)) Builtin {
+	result := Builtin{Decl: coordinatesDecl}
+	if fn == nil {
+		result.Func = notImplementedFunc(result.Decl, printFn)
+		return result
+	}
+	result.Func = func(args []Value) (Value, error) {
+		minX := args[0].(*Num)
+		minY := args[1].(*Num)
+		maxX := args[2].(*Num)
+		maxY := args[3].(*Num)
+		if minX.Val == maxX.Val || minY.Val == maxY.Val {
+			return nil, newError(ErrRange, "coordinates: min and max must differ, found "+join(args, " "))
+		}
+		fn(minX.Val, minY.Val, maxX.Val, maxY.Val)
+		return nil, nil
+	}
+	return result
+}
+
+var randomDecl = &parser.FuncDecl{
+	Name:       "random",
+	Params:     []*parser.Var{{Name: "n", T: parser.NUM_TYPE}},
+	ReturnType: parser.NUM_TYPE,
+}
+
+// maxRandom is the maximum n of random. It fits into an int on all
+// platforms, including 32 bit WebAssembly.
+const maxRandom = math.MaxInt32
+
+// randomFunc returns a builtin that returns a random integer in
+// [0, n). n must be a positive whole number not greater than
+// maxRandom.
+func randomFunc(rnd *rand.Rand) BuiltinFunc {
+	return func(args []Value) (Value, error) {
+		n := args[0].(*Num).Val
+		switch {
+		case n <= 0:
+			return nil, newError(ErrRange, "random: n must be a positive number, found "+args[0].String())
+		case n != math.Trunc(n):
+			return nil, newError(ErrRange, "random: n must be a whole number, found "+args[0].String())
+		case n > maxRandom:
+			return nil, newError(ErrRange, "random: n must not be greater than "+strconv.Itoa(maxRandom)+", found "+args[0].String())
+		}
+		return &Num{Val: float64(rnd.Intn(int(n)))}, nil
+	}
+}
+
+var randomfDecl = &parser.FuncDecl{
+	Name:       "randomf",
+	ReturnType: parser.NUM_TYPE,
+}
+
+// randomfFunc returns a builtin that returns a random number in
+// [0, 1).
+func randomfFunc(rnd *rand.Rand) BuiltinFunc {
+	return func(_ []Value) (Value, error) {
+		return &Num{Val: rnd.Float64()}, nil
+	}
+}
+
+var nowDecl = &parser.FuncDecl{
+	Name:       "now",
+	ReturnType: parser.NUM_TYPE,
+}
+
+// nowFunc returns a builtin that returns the current unix time in
+// seconds, with fractions of seconds.
+func nowFunc(clock Clock) BuiltinFunc {
+	return func(_ []Value) (Value, error) {
+		return &Num{Val: unixSeconds(clock.Now())}, nil
+	}
+}
+
+var formatTimeDecl = &parser.FuncDecl{
+	Name:       "format_time",
+	Params:     []*parser.Var{{Name: "t", T: parser.NUM_TYPE}},
+	ReturnType: parser.STRING_TYPE,
+}
+
+// formatTimeFunc formats unix seconds as RFC 3339 time in UTC, e.g.
+// "2022-08-28T23:59:05Z".
+func formatTimeFunc(args []Value) (Value, error) {
+	t := unixTime(args[0].(*Num).Val)
+	return &String{Val: t.Format(time.RFC3339)}, nil
+}
+
+var formatTimefDecl = &parser.FuncDecl{
+	Name: "format_timef",
+	Params: []*parser.Var{
+		{Name: "t", T: parser.NUM_TYPE},
+		{Name: "layout", T: parser.STRING_TYPE},
+	},
+	ReturnType: parser.STRING_TYPE,
+}
+
+// formatTimefFunc formats unix seconds in UTC with a Go time layout,
+// e.g. "06/01/02 15:04".
+func formatTimefFunc(args []Value) (Value, error) {
+	t := unixTime(args[0].(*Num).Val)
+	layout := args[1].(*String).Val
+	return &String{Val: t.Format(layout)}, nil
+}
+
+var parseTimeDecl = &parser.FuncDecl{
+	Name:       "parse_time",
+	Params:     []*parser.Var{{Name: "s", T: parser.STRING_TYPE}},
+	ReturnType: parser.NUM_TYPE,
+}
+
+// parseTimeFunc parses RFC 3339 time into unix seconds. On failure it
+// returns 0 and a recoverable conversion error.
+func parseTimeFunc(args []Value) (Value, error) {
+	return parseTime("parse_time", args[0].(*String).Val, time.RFC3339)
+}
+
+var parseTimefDecl = &parser.FuncDecl{
+	Name: "parse_timef",
+	Params: []*parser.Var{
+		{Name: "s", T: parser.STRING_TYPE},
+		{Name: "layout", T: parser.STRING_TYPE},
+	},
+	ReturnType: parser.NUM_TYPE,
+}
+
+// parseTimefFunc parses time with a Go time layout into unix seconds.
+// Times without time zone are interpreted as UTC. On failure it
+// returns 0 and a recoverable conversion error.
+func parseTimefFunc(args []Value) (Value, error) {
+	return parseTime("parse_timef", args[0].(*String).Val, args[1].(*String).Val)
+}
+
+func parseTime(name, s, layout string) (Value, error) {
+	t, err := time.Parse(layout, s)
+	if err != nil {
+		return &Num{}, newRecoverableError(ErrConversion, name+": "+err.Error())
+	}
+	return &Num{Val: unixSeconds(t)}, nil
+}
+
+var sleepDecl = &parser.FuncDecl{
+	Name:       "sleep",
+	Params:     []*parser.Var{{Name: "secs", T: parser.NUM_TYPE}},
+	ReturnType: parser.NONE_TYPE,
+}
+
+func sleepFunc(clock Clock) BuiltinFunc {
+	return func(args []Value) (Value, error) {
+		secs := args[0].(*Num).Val
+		clock.Sleep(time.Duration(secs * float64(time.Second)))
+		return nil, nil
+	}
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.Unix()) + float64(t.Nanosecond())/1e9
+}
+
+func unixTime(secs float64) time.Time {
+	whole, frac := math.Modf(secs)
+	return time.Unix(int64(whole), int64(frac*1e9)).UTC()
+}
+
+var readDecl = &parser.FuncDecl{
+	Name:       "read",
+	ReturnType: parser.STRING_TYPE,
+}
+
+var readlnDecl = &parser.FuncDecl{
+	Name:       "readln",
+	ReturnType: parser.STRING_TYPE,
+}
+
+// inputBuiltin creates a builtin for decl reading input with fn. If
+// reading fails, e.g. at the end of input, it returns "" and a
+// recoverable input error.
+func inputBuiltin(decl *parser.FuncDecl, fn func() (string, error), readLine func() (string, error), printFn func(string)) Builtin {
+	result := Builtin{Decl: decl, Recoverable: true}
+	if readLine == nil {
+		result.Func = notImplementedFunc(decl, printFn)
+		return result
+	}
+	result.Func = func(_ []Value) (Value, error) {
+		s, err := fn()
+		if err != nil {
+			return &String{}, newRecoverableError(ErrInput, decl.Name+": "+err.Error())
+		}
+		return &String{Val: s}, nil
+	}
+	return result
+}
+
+// lineReader splits the input lines returned by readLine into words
+// for the read builtin, while readln returns the rest of the current
+// line or the next line.
+type lineReader struct {
+	readLine func() (string, error)
+	rest     string // unread remainder of the current line
+}
+
+func (r *lineReader) word() (string, error) {
+	for {
+		r.rest = strings.TrimLeftFunc(r.rest, unicode.IsSpace)
+		if r.rest != "" {
+			break
+		}
+		line, err := r.readLine()
+		if err != nil {
+			return "", err
+		}
+		r.rest = line
+	}
+	word := r.rest
+	if i := strings.IndexFunc(r.rest, unicode.IsSpace); i != -1 {
+		word = r.rest[:i]
+	}
+	r.rest = r.rest[len(word):]
+	return word, nil
+}
+
+func (r *lineReader) line() (string, error) {
+	rest := strings.TrimLeftFunc(r.rest, unicode.IsSpace)
+	r.rest = ""
+	if rest != "" {
+		return rest, nil
+	}
+	return r.readLine()
+}
+
+var readidDecl = &parser.FuncDecl{
+	Name:       "readid",
+	Params:     []*parser.Var{{Name: "selector", T: parser.STRING_TYPE}},
+	ReturnType: parser.STRING_TYPE,
+}
+
+func readidBuiltin(fn func(selector string) string, printFn func(string)) Builtin {
+	result := Builtin{Decl: readidDecl}
+	if fn == nil {
+		result.Func = notImplementedFunc(readidDecl, printFn)
+		return result
+	}
+	result.Func = func(args []Value) (Value, error) {
+		selector := args[0].(*String)
+		return &String{Val: fn(selector.Val)}, nil
+	}
+	return result
+}
+
+// mathBuiltin1 creates a builtin for a math function with a single num
+// parameter, e.g. sqrt. Trigonometric functions work in radians.
+func mathBuiltin1(name string, fn func(float64) float64) Builtin {
+	decl := &parser.FuncDecl{
+		Name:       name,
+		Params:     []*parser.Var{{Name: "n", T: parser.NUM_TYPE}},
+		ReturnType: parser.NUM_TYPE,
+	}
+	f := func(args []Value) (Value, error) {
+		n := args[0].(*Num)
+		return &Num{Val: fn(n.Val)}, nil
+	}
+	return Builtin{Func: f, Decl: decl}
+}
+
+// mathBuiltin2 creates a builtin for a math function with two num
+// parameters, e.g. pow.
+func mathBuiltin2(name string, fn func(float64, float64) float64) Builtin {
+	decl := &parser.FuncDecl{
+		Name: name,
+		Params: []*parser.Var{
+			{Name: "a", T: parser.NUM_TYPE},
+			{Name: "b", T: parser.NUM_TYPE},
+		},
+		ReturnType: parser.NUM_TYPE,
+	}
+	f := func(args []Value) (Value, error) {
+		a := args[0].(*Num)
+		b := args[1].(*Num)
+		return &Num{Val: fn(a.Val, b.Val)}, nil
+	}
+	return Builtin{Func: f, Decl: decl}
+}
+
+// div is integer division truncated towards zero, e.g. `div -7 2` is
+// -3.
+func div(a, b float64) float64 {
+	return math.Trunc(a / b)
+}
+
 func stringDecl(name string) *parser.FuncDecl {
 	return &parser.FuncDecl{
 		Name: name,
@@ -254,20 +1174,23 @@ func stringDecl(name string) *parser.FuncDecl {
 func stringBuiltin(name string, fn func(str string), printFn func(string)) Builtin {
 	result := Builtin{Decl: stringDecl(name)}
 	if fn == nil {
-		result.Func = notImplementedFunc(name, printFn)
+		result.Func = notImplementedFunc(result.Decl, printFn)
 		return result
 	}
-	result.Func = func(args []Value) Value {
+	result.Func = func(args []Value) (Value, error) {
 		str := args[0].(*String)
 		fn(str.Val)
-		return nil
+		return nil, nil
 	}
 	return result
 }
 
-func notImplementedFunc(name string, printFn func(string)) BuiltinFunc {
-	return func(args []Value) Value {
-		printFn("'" + name + "' not yet implemented\n")
-		return nil
+// notImplementedFunc returns a builtin function for decl that prints a
+// "not yet implemented" message and returns the zero value of the
+// return type, if any.
+func notImplementedFunc(decl *parser.FuncDecl, printFn func(string)) BuiltinFunc {
+	return func(args []Value) (Value, error) {
+		printFn("'" + decl.Name + "' not yet implemented\n")
+		return zero(decl.ReturnType), nil
 	}
 }
@@ -0,0 +1,327 @@
+package evaluator
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// parseColor parses a CSS colour and returns it normalised as
+// #rrggbb, or #rrggbbaa if it is not opaque. Supported are colour
+// keywords, e.g. "red", hex colours #rgb, #rgba, #rrggbb and #rrggbbaa
+// as well as the functions rgb(), rgba(), hsl() and hsla(). The
+// special colour "none" is returned unchanged.
+func parseColor(s string) (string, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "none" {
+		return s, true
+	}
+	if hex, ok := colorNames[s]; ok {
+		s = hex
+	}
+	var c rgba
+	var ok bool
+	switch {
+	case strings.HasPrefix(s, "#"):
+		c, ok = parseHexColor(s[1:])
+	case strings.HasPrefix(s, "rgb"):
+		c, ok = parseColorFunc(s, "rgb", rgbColor)
+	case strings.HasPrefix(s, "hsl"):
+		c, ok = parseColorFunc(s, "hsl", hslColor)
+	}
+	if !ok {
+		return "", false
+	}
+	return c.String(), true
+}
+
+// numColor returns the colour for the numeric shorthand rgb with
+// three decimal digits from 0 to 9 for red, green and blue, e.g. 900
+// for red, 90 for green.
+func numColor(n float64) (string, bool) {
+	if n < 0 || n > 999 || n != math.Trunc(n) {
+		return "", false
+	}
+	digit := func(d int) uint8 { return uint8(math.Round(float64(d) * 255 / 9)) }
+	i := int(n)
+	c := rgba{r: digit(i / 100), g: digit(i / 10 % 10), b: digit(i % 10), a: 255}
+	return c.String(), true
+}
+
+type rgba struct {
+	r, g, b, a uint8
+}
+
+func (c rgba) String() string {
+	s := "#" + hexByte(c.r) + hexByte(c.g) + hexByte(c.b)
+	if c.a != 255 {
+		s += hexByte(c.a)
+	}
+	return s
+}
+
+func hexByte(b uint8) string {
+	const digits = "0123456789abcdef"
+	return string([]byte{digits[b>>4], digits[b&0xf]})
+}
+
+func parseHexColor(hex string) (rgba, bool) {
+	if len(hex) == 3 || len(hex) == 4 {
+		var sb strings.Builder
+		for _, r := range hex {
+			sb.WriteRune(r)
+			sb.WriteRune(r)
+		}
+		hex = sb.String()
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	if len(hex) != 8 {
+		return rgba{}, false
+	}
+	n, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return rgba{}, false
+	}
+	return rgba{r: uint8(n >> 24), g: uint8(n >> 16), b: uint8(n >> 8), a: uint8(n)}, true
+}
+
+// parseColorFunc parses a CSS colour function such as "rgb(255 0 0)",
+// "rgba(255, 0, 0, 0.5)" or "hsl(120deg 100% 50% / 50%)" and converts
+// its three arguments with toRGB.
+func parseColorFunc(s, name string, toRGB func(args []string) (rgba, bool)) (rgba, bool) {
+	s = strings.TrimPrefix(s, name)
+	s = strings.TrimPrefix(s, "a")
+	if !strings.HasPrefix(s, "(") || !strings.HasSuffix(s, ")") {
+		return rgba{}, false
+	}
+	args := strings.FieldsFunc(s[1:len(s)-1], func(r rune) bool {
+		return r == ',' || r == '/' || r == ' ' || r == '\t'
+	})
+	if len(args) != 3 && len(args) != 4 {
+		return rgba{}, false
+	}
+	c, ok := toRGB(args[:3])
+	if !ok {
+		return rgba{}, false
+	}
+	if len(args) == 4 {
+		a, ok := parseColorNum(args[3], 1)
+		if !ok {
+			return rgba{}, false
+		}
+		c.a = toByte(a)
+	}
+	return c, true
+}
+
+func rgbColor(args []string) (rgba, bool) {
+	var channels [3]uint8
+	for i, arg := range args {
+		n, ok := parseColorNum(arg, 255)
+		if !ok {
+			return rgba{}, false
+		}
+		channels[i] = toByte(n / 255)
+	}
+	return rgba{r: channels[0], g: channels[1], b: channels[2], a: 255}, true
+}
+
+// hslColor converts hue in degrees, saturation and lightness in
+// percent to RGB, see https://www.w3.org/TR/css-color-4/#hsl-to-rgb
+func hslColor(args []string) (rgba, bool) {
+	h, err := strconv.ParseFloat(strings.TrimSuffix(args[0], "deg"), 64)
+	if err != nil {
+		return rgba{}, false
+	}
+	sat, ok1 := parseColorNum(args[1], 100)
+	light, ok2 := parseColorNum(args[2], 100)
+	if !ok1 || !ok2 {
+		return rgba{}, false
+	}
+	sat, light = sat/100, light/100
+	h = math.Mod(h, 360)
+	if h < 0 {
+		h += 360
+	}
+	f := func(n float64) uint8 {
+		k := math.Mod(n+h/30, 12)
+		a := sat * math.Min(light, 1-light)
+		return toByte(light - a*math.Max(-1, math.Min(k-3, math.Min(9-k, 1))))
+	}
+	return rgba{r: f(0), g: f(8), b: f(4), a: 255}, true
+}
+
+// parseColorNum parses a number or a percentage of limit and clamps
+// it to [0, limit].
+func parseColorNum(s string, limit float64) (float64, bool) {
+	percent := strings.HasSuffix(s, "%")
+	n, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil || math.IsNaN(n) {
+		return 0, false
+	}
+	if percent {
+		n = n / 100 * limit
+	}
+	return math.Max(0, math.Min(limit, n)), true
+}
+
+// toByte converts a colour channel value in [0, 1] to a byte.
+func toByte(f float64) uint8 {
+	return uint8(math.Round(f * 255))
+}
+
+// colorNames maps CSS colour keywords to hex colours, see
+// https://developer.mozilla.org/en-US/docs/Web/CSS/named-color
+var colorNames = map[string]string{
+	"transparent":          "#00000000",
+	"aliceblue":            "#f0f8ff",
+	"antiquewhite":         "#faebd7",
+	"aqua":                 "#00ffff",
+	"aquamarine":           "#7fffd4",
+	"azure":                "#f0ffff",
+	"beige":                "#f5f5dc",
+	"bisque":               "#ffe4c4",
+	"black":                "#000000",
+	"blanchedalmond":       "#ffebcd",
+	"blue":                 "#0000ff",
+	"blueviolet":           "#8a2be2",
+	"brown":                "#a52a2a",
+	"burlywood":            "#deb887",
+	"cadetblue":            "#5f9ea0",
+	"chartreuse":           "#7fff00",
+	"chocolate":            "#d2691e",
+	"coral":                "#ff7f50",
+	"cornflowerblue":       "#6495ed",
+	"cornsilk":             "#fff8dc",
+	"crimson":              "#dc143c",
+	"cyan":                 "#00ffff",
+	"darkblue":             "#00008b",
+	"darkcyan":             "#008b8b",
+	"darkgoldenrod":        "#b8860b",
+	"darkgray":             "#a9a9a9",
+	"darkgreen":            "#006400",
+	"darkgrey":             "#a9a9a9",
+	"darkkhaki":            "#bdb76b",
+	"darkmagenta":          "#8b008b",
+	"darkolivegreen":       "#556b2f",
+	"darkorange":           "#ff8c00",
+	"darkorchid":           "#9932cc",
+	"darkred":              "#8b0000",
+	"darksalmon":           "#e9967a",
+	"darkseagreen":         "#8fbc8f",
+	"darkslateblue":        "#483d8b",
+	"darkslategray":        "#2f4f4f",
+	"darkslategrey":        "#2f4f4f",
+	"darkturquoise":        "#00ced1",
+	"darkviolet":           "#9400d3",
+	"deeppink":             "#ff1493",
+	"deepskyblue":          "#00bfff",
+	"dimgray":              "#696969",
+	"dimgrey":              "#696969",
+	"dodgerblue":           "#1e90ff",
+	"firebrick":            "#b22222",
+	"floralwhite":          "#fffaf0",
+	"forestgreen":          "#228b22",
+	"fuchsia":              "#ff00ff",
+	"gainsboro":            "#dcdcdc",
+	"ghostwhite":           "#f8f8ff",
+	"gold":                 "#ffd700",
+	"goldenrod":            "#daa520",
+	"gray":                 "#808080",
+	"green":                "#008000",
+	"greenyellow":          "#adff2f",
+	"grey":                 "#808080",
+	"honeydew":             "#f0fff0",
+	"hotpink":              "#ff69b4",
+	"indianred":            "#cd5c5c",
+	"indigo":               "#4b0082",
+	"ivory":                "#fffff0",
+	"khaki":                "#f0e68c",
+	"lavender":             "#e6e6fa",
+	"lavenderblush":        "#fff0f5",
+	"lawngreen":            "#7cfc00",
+	"lemonchiffon":         "#fffacd",
+	"lightblue":            "#add8e6",
+	"lightcoral":           "#f08080",
+	"lightcyan":            "#e0ffff",
+	"lightgoldenrodyellow": "#fafad2",
+	"lightgray":            "#d3d3d3",
+	"lightgreen":           "#90ee90",
+	"lightgrey":            "#d3d3d3",
+	"lightpink":            "#ffb6c1",
+	"lightsalmon":          "#ffa07a",
+	"lightseagreen":        "#20b2aa",
+	"lightskyblue":         "#87cefa",
+	"lightslategray":       "#778899",
+	"lightslategrey":       "#778899",
+	"lightsteelblue":       "#b0c4de",
+	"lightyellow":          "#ffffe0",
+	"lime":                 "#00ff00",
+	"limegreen":            "#32cd32",
+	"linen":                "#faf0e6",
+	"magenta":              "#ff00ff",
+	"maroon":               "#800000",
+	"mediumaquamarine":     "#66cdaa",
+	"mediumblue":           "#0000cd",
+	"mediumorchid":         "#ba55d3",
+	"mediumpurple":         "#9370db",
+	"mediumseagreen":       "#3cb371",
+	"mediumslateblue":      "#7b68ee",
+	"mediumspringgreen":    "#00fa9a",
+	"mediumturquoise":      "#48d1cc",
+	"mediumvioletred":      "#c71585",
+	"midnightblue":         "#191970",
+	"mintcream":            "#f5fffa",
+	"mistyrose":            "#ffe4e1",
+	"moccasin":             "#ffe4b5",
+	"navajowhite":          "#ffdead",
+	"navy":                 "#000080",
+	"oldlace":              "#fdf5e6",
+	"olive":                "#808000",
+	"olivedrab":            "#6b8e23",
+	"orange":               "#ffa500",
+	"orangered":            "#ff4500",
+	"orchid":               "#da70d6",
+	"palegoldenrod":        "#eee8aa",
+	"palegreen":            "#98fb98",
+	"paleturquoise":        "#afeeee",
+	"palevioletred":        "#db7093",
+	"papayawhip":           "#ffefd5",
+	"peachpuff":            "#ffdab9",
+	"peru":                 "#cd853f",
+	"pink":                 "#ffc0cb",
+	"plum":                 "#dda0dd",
+	"powderblue":           "#b0e0e6",
+	"purple":               "#800080",
+	"rebeccapurple":        "#663399",
+	"red":                  "#ff0000",
+	"rosybrown":            "#bc8f8f",
+	"royalblue":            "#4169e1",
+	"saddlebrown":          "#8b4513",
+	"salmon":               "#fa8072",
+	"sandybrown":           "#f4a460",
+	"seagreen":             "#2e8b57",
+	"seashell":             "#fff5ee",
+	"sienna":               "#a0522d",
+	"silver":               "#c0c0c0",
+	"skyblue":              "#87ceeb",
+	"slateblue":            "#6a5acd",
+	"slategray":            "#708090",
+	"slategrey":            "#708090",
+	"snow":                 "#fffafa",
+	"springgreen":          "#00ff7f",
+	"steelblue":            "#4682b4",
+	"tan":                  "#d2b48c",
+	"teal":                 "#008080",
+	"thistle":              "#d8bfd8",
+	"tomato":               "#ff6347",
+	"turquoise":            "#40e0d0",
+	"violet":               "#ee82ee",
+	"wheat":                "#f5deb3",
+	"white":                "#ffffff",
+	"whitesmoke":           "#f5f5f5",
+	"yellow":               "#ffff00",
+	"yellowgreen":          "#9acd32",
+}
@@ -0,0 +1,154 @@
+package evaluator
+
+import (
+	"strings"
+
+	"foxygo.at/evy/pkg/lexer"
+	"foxygo.at/evy/pkg/parser"
+)
+
+// ErrorKind categorises runtime errors, e.g. ErrBounds for an index
+// out of bounds. The kinds of recoverable errors come first, as their
+// values are exposed to evy programs as `errnum`, where 0 means no
+// error.
+type ErrorKind int
+
+const (
+	ErrConversion ErrorKind = iota + 1 // failed type conversion, e.g. str2num
+	ErrInput                           // failed reading input, e.g. end of input
+	ErrBounds                          // index or slice indices out of bounds
+	ErrAssertion                       // failed type assertion
+	ErrInternal                        // unexpected error, likely an evy bug
+	ErrKey                             // map key not found
+	ErrRange                           // invalid range, e.g. step 0
+	ErrPanic                           // explicit call to the panic builtin
+	ErrExit                            // explicit call to the exit builtin
+)
+
+var errorKindStrings = map[ErrorKind]string{
+	ErrConversion: "conversion error",
+	ErrInput:      "input error",
+	ErrBounds:     "bounds error",
+	ErrAssertion:  "assertion error",
+	ErrInternal:   "internal error",
+	ErrKey:        "key error",
+	ErrRange:      "range error",
+	ErrPanic:      "panic",
+	ErrExit:       "exit",
+}
+
+func (k ErrorKind) String() string {
+	if s, ok := errorKindStrings[k]; ok {
+		return s
+	}
+	return "<UNKNOWN>"
+}
+
+// Error is a runtime error. Token is the location in the evy source
+// code where the error occurred and Stack holds the names of the evy
+// functions and event handlers being executed at the time, outermost
+// first.
+//
+// Recoverable errors, returned by some builtin functions, index and
+// slice expressions and type assertions, do not stop the execution of
+// the evy program. Instead, they set the global `error`
+// and `errnum` variables to Message and Kind.
+type Error struct {
+	Kind        ErrorKind
+	Message     string
+	Token       *lexer.Token
+	Stack       []string
+	Recoverable bool
+}
+
+func (e *Error) Error() string {
+	if e.Token == nil {
+		return e.Message
+	}
+	return e.Token.Location() + ": " + e.Message
+}
+
+// StackString returns the call stack of the error as a string with
+// the innermost function on the first line.
+func (e *Error) StackString() string {
+	lines := make([]string, len(e.Stack))
+	for i, name := range e.Stack {
+		lines[len(lines)-1-i] = name
+	}
+	return strings.Join(lines, "\n")
+}
+
+// IsExit reports whether err was caused by the exit builtin. It stops
+// the evy program, including its event loop, but is not a failure.
+func IsExit(err error) bool {
+	evyErr, ok := err.(*Error)
+	return ok && evyErr.Kind == ErrExit
+}
+
+func newError(kind ErrorKind, msg string) *Error {
+	return &Error{Kind: kind, Message: msg}
+}
+
+func newRecoverableError(kind ErrorKind, msg string) *Error {
+	return &Error{Kind: kind, Message: msg, Recoverable: true}
+}
+
+// annotate adds the location of node and the current call stack to err
+// if they have not yet been set by a more deeply nested node.
+func (e *Evaluator) annotate(err error, node parser.Node) error {
+	evyErr, ok := err.(*Error)
+	if !ok {
+		evyErr = newError(ErrInternal, err.Error())
+	}
+	if evyErr.Token != nil {
+		return evyErr
+	}
+	evyErr.Token = nodeToken(node)
+	evyErr.Stack = make([]string, len(e.stack))
+	copy(evyErr.Stack, e.stack)
+	return evyErr
+}
+
+func nodeToken(node parser.Node) *lexer.Token {
+	switch n := node.(type) {
+	case *parser.FunctionCall:
+		return n.Token
+	case *parser.UnaryExpression:
+		return n.Token
+	case *parser.BinaryExpression:
+		return n.Token
+	case *parser.IndexExpression:
+		return n.Token
+	case *parser.SliceExpression:
+		return n.Token
+	case *parser.DotExpression:
+		return n.Token
+	case *parser.TypeAssertion:
+		return n.Token
+	case *parser.Declaration:
+		return n.Token
+	case *parser.Assignment:
+		return n.Token
+	case *parser.Return:
+		return n.Token
+	case *parser.If:
+		return n.Token
+	case *parser.While:
+		return n.Token
+	case *parser.For:
+		return n.Token
+	case *parser.StepRange:
+		return n.Token
+	case *parser.EventHandler:
+		return n.Token
+	case *parser.Var:
+		return n.Token
+	case *parser.BlockStatement:
+		return n.Token
+	case *parser.ArrayLiteral:
+		return n.Token
+	case *parser.MapLiteral:
+		return n.Token
+	}
+	return nil
+}
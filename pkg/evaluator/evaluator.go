@@ -4,6 +4,10 @@
 package evaluator
 
 import (
+	"errors"
+	"math"
+	"sync/atomic"
+
 	"foxygo.at/evy/pkg/parser"
 )
 
@@ -12,27 +16,87 @@ func Run(input string, printFn func(string)) {
 	RunWithBuiltins(input, DefaultBuiltins(rt))
 }
 
+// RunWithBuiltins runs the given evy program with RunWithBuiltinsErr
+// and prints the error, if any.
 func RunWithBuiltins(input string, builtins Builtins) {
-	p := parser.New(input, builtins.Decls())
+	if err := RunWithBuiltinsErr(input, builtins); err != nil {
+		builtins.Print(err.Error())
+	}
+}
+
+// RunWithBuiltinsErr parses and evaluates the given evy program,
+// followed by its event loop. Parse errors are returned as a single
+// error with one line per parse error. Runtime errors are returned as
+// *Error. Stopping the program with the exit builtin is not an error.
+func RunWithBuiltinsErr(input string, builtins Builtins) error {
+	e := NewEvaluator(builtins)
+	err := e.Run(input)
+	if err == nil {
+		err = e.runEventLoop(builtins.Events, builtins.Ticker)
+	}
+	if IsExit(err) {
+		return nil
+	}
+	return err
+}
+
+// NewEvaluator creates a new Evaluator for the given builtins. Run
+// evaluates the top-level code of an evy program, after which events
+// can be passed to HandleEvent.
+func NewEvaluator(builtins Builtins) *Evaluator {
+	globals := newScope()
+	for name, val := range builtins.Globals {
+		globals.set(name, copyOrRef(val))
+	}
+	return &Evaluator{print: builtins.Print, builtins: builtins, globals: globals}
+}
+
+// Run parses and evaluates the top-level code of the given input and
+// registers its event handlers.
+func (e *Evaluator) Run(input string) error {
+	p := parser.New(input, e.builtins.Decls())
 	prog := p.Parse()
 	if p.HasErrors() {
-		builtins.Print(p.MaxErrorsString(8))
-		return
+		return errors.New(p.MaxErrorsString(8))
 	}
-	e := &Evaluator{print: builtins.Print}
-	e.builtins = builtins.Funcs
-	val := e.Eval(newScope(), prog)
-	if isError(val) {
-		builtins.Print(val.String())
+	e.global = newInnerScope(e.globals)
+	if _, err := e.Eval(e.global, prog); err != nil {
+		return err
 	}
+	e.registerEventHandlers(prog.EventHandlers)
+	return nil
 }
 
 type Evaluator struct {
 	print    func(string)
-	builtins map[string]Builtin
+	builtins Builtins
+	globals  *scope   // builtin global variables, such as `error`
+	global   *scope   // top-level variables of the evy program
+	stack    []string // names of the evy functions currently being called
+	stopped  atomic.Bool
+
+	eventHandlers map[string]*parser.EventHandler
+}
+
+// Stop stops the evaluation of the program, including its event
+// handlers, before the next statement, as if the exit builtin had been
+// called. It can be called from another goroutine, e.g. while the
+// program sleeps.
+func (e *Evaluator) Stop() {
+	e.stopped.Store(true)
+}
+
+// Eval evaluates node. Runtime errors are returned as *Error annotated
+// with the location of the innermost node that caused the error.
+func (e *Evaluator) Eval(scope *scope, node parser.Node) (Value, error) {
+	val, err := e.eval(scope, node)
+	if err != nil {
+		return nil, e.annotate(err, node)
+	}
+	return val, nil
 }
 
-func (e *Evaluator) Eval(scope *scope, node parser.Node) Value {
+func (e *Evaluator) eval(scope *scope, node parser.Node) (Value, error) {
 	switch node := node.(type) {
 	case *parser.Program:
 		return e.evalProgram(scope, node)
@@ -43,11 +107,11 @@ func (e *Evaluator) Eval(scope *scope, node parser.Node) Value {
 	case *parser.Var:
 		return e.evalVar(scope, node)
 	case *parser.NumLiteral:
-		return &Num{Val: node.Value}
+		return &Num{Val: node.Value}, nil
 	case *parser.StringLiteral:
-		return &String{Val: node.Value}
+		return &String{Val: node.Value}, nil
 	case *parser.Bool:
-		return e.evalBool(node)
+		return e.evalBool(node), nil
 	case *parser.ArrayLiteral:
 		return e.evalArrayLiteral(scope, node)
 	case *parser.MapLiteral:
@@ -57,7 +121,7 @@ func (e *Evaluator) Eval(scope *scope, node parser.Node) Value {
 	case *parser.Return:
 		return e.evalReturn(scope, node)
 	case *parser.Break:
-		return e.evalBreak(scope, node)
+		return e.evalBreak(scope, node), nil
 	case *parser.If:
 		return e.evalIf(scope, node)
 	case *parser.While:
@@ -76,168 +140,295 @@ func (e *Evaluator) Eval(scope *scope, node parser.Node) Value {
 		return e.evalSliceExpr(scope, node)
 	case *parser.DotExpression:
 		return e.evalDotExpr(scope, node, false /* forAssign */)
+	case *parser.TypeAssertion:
+		return e.evalTypeAssertion(scope, node)
 	}
-	return nil // TODO: panic?
+	return nil, nil // TODO: panic?
 }
 
-func (e *Evaluator) evalProgram(scope *scope, program *parser.Program) Value {
+func (e *Evaluator) evalProgram(scope *scope, program *parser.Program) (Value, error) {
 	return e.evalStatments(scope, program.Statements)
 }
 
-func (e *Evaluator) evalStatments(scope *scope, statements []parser.Node) Value {
+func (e *Evaluator) evalStatments(scope *scope, statements []parser.Node) (Value, error) {
 	var result Value
 	for _, statement := range statements {
-		result = e.Eval(scope, statement)
-		if isError(result) || isReturn(result) || isBreak(result) {
-			return result
+		if e.stopped.Load() {
+			return nil, newError(ErrExit, "stopped")
+		}
+		var err error
+		result, err = e.Eval(scope, statement)
+		if err != nil {
+			return nil, err
+		}
+		if isReturn(result) || isBreak(result) {
+			return result, nil
 		}
 	}
-	return result
+	return result, nil
 }
 
 func (e *Evaluator) evalBool(b *parser.Bool) Value {
 	return &Bool{Val: b.Value}
 }
 
-func (e *Evaluator) evalDeclaration(scope *scope, decl *parser.Declaration) Value {
-	val := e.Eval(scope, decl.Value)
-	if isError(val) {
-		return val
-	}
-	if decl.Type() == parser.ANY_TYPE && val.Type() != ANY {
-		val = &Any{Val: val}
+func (e *Evaluator) evalDeclaration(scope *scope, decl *parser.Declaration) (Value, error) {
+	val, err := e.Eval(scope, decl.Value)
+	if err != nil {
+		return nil, err
 	}
-	scope.set(decl.Var.Name, copyOrRef(val))
-	return nil
+	scope.set(decl.Var.Name, copyOrRef(wrapAny(val, decl.Type())))
+	return nil, nil
 }
 
-func (e *Evaluator) evalAssignment(scope *scope, assignment *parser.Assignment) Value {
-	val := e.Eval(scope, assignment.Value)
-	if isError(val) {
-		return val
+func (e *Evaluator) evalAssignment(scope *scope, assignment *parser.Assignment) (Value, error) {
+	val, err := e.Eval(scope, assignment.Value)
+	if err != nil {
+		return nil, err
 	}
-	target := e.evalTarget(scope, assignment.Target)
-	if isError(target) {
-		return target
+	target, err := e.evalTarget(scope, assignment.Target)
+	if err != nil {
+		return nil, err
 	}
 	target.Set(val)
-	return nil
+	return nil, nil
 }
 
-func (e *Evaluator) evalArrayLiteral(scope *scope, arr *parser.ArrayLiteral) Value {
-	elements := e.evalExprList(scope, arr.Elements)
-	if len(elements) == 1 && isError(elements[0]) {
-		return elements[0]
+func (e *Evaluator) evalArrayLiteral(scope *scope, arr *parser.ArrayLiteral) (Value, error) {
+	elements, err := e.evalExprList(scope, arr.Elements)
+	if err != nil {
+		return nil, err
+	}
+	for i, el := range elements {
+		elements[i] = wrapAny(el, arr.Type().Sub)
 	}
-	return &Array{Elements: &elements}
+	return &Array{Elements: &elements}, nil
 }
 
-func (e *Evaluator) evalMapLiteral(scope *scope, m *parser.MapLiteral) Value {
+func (e *Evaluator) evalMapLiteral(scope *scope, m *parser.MapLiteral) (Value, error) {
 	pairs := map[string]Value{}
 	for key, node := range m.Pairs {
-		val := e.Eval(scope, node)
-		if isError(val) {
-			return val
+		val, err := e.Eval(scope, node)
+		if err != nil {
+			return nil, err
 		}
-		pairs[key] = copyOrRef(val)
+		pairs[key] = wrapAny(copyOrRef(val), m.Type().Sub)
 	}
 	order := make([]string, len(m.Order))
 	copy(order, m.Order)
-	return &Map{Pairs: pairs, Order: &order}
+	return &Map{Pairs: pairs, Order: &order}, nil
 }
 
-func (e *Evaluator) evalFunctionCall(scope *scope, funcCall *parser.FunctionCall) Value {
-	args := e.evalExprList(scope, funcCall.Arguments)
-	if len(args) == 1 && isError(args[0]) {
-		return args[0]
+func (e *Evaluator) evalFunctionCall(scope *scope, funcCall *parser.FunctionCall) (Value, error) {
+	args, err := e.evalExprList(scope, funcCall.Arguments)
+	if err != nil {
+		return nil, err
 	}
-	builtin, ok := e.builtins[funcCall.Name]
+	builtin, ok := e.builtins.Funcs[funcCall.Name]
 	if ok {
-		return builtin.Func(args)
+		if funcCall.Spread {
+			args = *args[0].(*Array).Elements
+		} else {
+			wrapGenericArgs(funcCall, args)
+		}
+		return e.callBuiltin(builtin, args)
+	}
+	if funcCall.Spread {
+		arrType := funcCall.Arguments[0].Type()
+		scope = innerScopeWithSpread(scope, funcCall.FuncDecl, args[0].(*Array), arrType)
+	} else {
+		scope = innerScopeWithArgs(scope, funcCall.FuncDecl, args)
+	}
+	e.stack = append(e.stack, funcCall.Name)
+	funcResult, err := e.Eval(scope, funcCall.FuncDecl.Body)
+	e.stack = e.stack[:len(e.stack)-1]
+	if err != nil {
+		return nil, err
 	}
-	scope = innerScopeWithArgs(scope, funcCall.FuncDecl, args)
-	funcResult := e.Eval(scope, funcCall.FuncDecl.Body)
 	if returnValue, ok := funcResult.(*ReturnValue); ok {
-		return returnValue.Val
+		return returnValue.Val, nil
+	}
+	return nil, nil
+}
+
+// callBuiltin calls builtin with args. Calls to recoverable builtins
+// set the global `error` and `errnum` variables if the builtin returns
+// a recoverable error and reset them otherwise. Other builtins leave
+// them unchanged, so that the last error can be printed and checked.
+func (e *Evaluator) callBuiltin(builtin Builtin, args []Value) (Value, error) {
+	val, err := builtin.Func(args)
+	if err == nil {
+		if builtin.Recoverable {
+			e.setErrorState("", 0)
+		}
+		return val, nil
+	}
+	if evyErr, ok := err.(*Error); ok && evyErr.Recoverable {
+		e.setErrorState(evyErr.Message, evyErr.Kind)
+		return val, nil
+	}
+	return nil, err
+}
+
+// recoverError sets the global `error` and `errnum` variables and
+// returns the zero value of type t if err is recoverable. Otherwise, it
+// resets them if err is nil, like callBuiltin for recoverable builtins.
+func (e *Evaluator) recoverError(val Value, err error, t *parser.Type) (Value, error) {
+	if err == nil {
+		e.setErrorState("", 0)
+		return val, nil
+	}
+	if evyErr, ok := err.(*Error); ok && evyErr.Recoverable {
+		e.setErrorState(evyErr.Message, evyErr.Kind)
+		return zero(t), nil
+	}
+	return nil, err
+}
+
+// setErrorState sets the global `error` and `errnum` variables if they
+// are defined. New values are created rather than updated in place, as
+// the old values may still be referenced, e.g. as arguments.
+func (e *Evaluator) setErrorState(msg string, kind ErrorKind) {
+	if errnum, ok := e.globals.get("errnum"); ok && kind == 0 && errnum.(*Num).Val == 0 {
+		return // no error to reset, avoid allocations
+	}
+	if _, ok := e.globals.get("error"); ok {
+		e.globals.set("error", &String{Val: msg})
+	}
+	if _, ok := e.globals.get("errnum"); ok {
+		e.globals.set("errnum", &Num{Val: float64(kind)})
+	}
+}
+
+// wrapGenericArgs wraps builtin arguments for GENERIC_ELEMENT
+// parameters in *Any if the element type of the preceding array
+// argument is any, as resolved by parser.assertArgTypes.
+func wrapGenericArgs(funcCall *parser.FunctionCall, args []Value) {
+	var arrType *parser.Type
+	for i, param := range funcCall.FuncDecl.Params {
+		if i >= len(args) {
+			return
+		}
+		switch param.T {
+		case parser.GENERIC_ARRAY:
+			arrType = funcCall.Arguments[i].Type()
+		case parser.GENERIC_ELEMENT:
+			if arrType != nil {
+				args[i] = wrapAny(args[i], arrType.Sub)
+			}
+		}
 	}
-	return funcResult // error or nil
 }
 
 func innerScopeWithArgs(scope *scope, fd *parser.FuncDecl, args []Value) *scope {
 	scope = newInnerScope(scope)
 	for i, param := range fd.Params {
-		scope.set(param.Name, args[i])
+		scope.set(param.Name, wrapAny(args[i], param.T))
 	}
 	if fd.VariadicParam != nil {
-		varArg := &Array{Elements: &args}
-		scope.set(fd.VariadicParam.Name, varArg)
+		varArgs := args[len(fd.Params):]
+		for i, arg := range varArgs {
+			varArgs[i] = wrapAny(arg, fd.VariadicParam.T)
+		}
+		scope.set(fd.VariadicParam.Name, &Array{Elements: &varArgs})
+	}
+	return scope
+}
+
+// innerScopeWithSpread passes the spread array argument `arr...` of
+// type arrType as is to the variadic parameter, if its element type
+// matches the parameter type. Otherwise, e.g. for a []num spread into
+// `any...`, the elements are copied into a new array, so that the
+// caller's array keeps its element type.
+func innerScopeWithSpread(scope *scope, fd *parser.FuncDecl, arr *Array, arrType *parser.Type) *scope {
+	scope = newInnerScope(scope)
+	param := fd.VariadicParam
+	if arrType.Sub == nil || !param.T.Matches(arrType.Sub) {
+		elements := make([]Value, len(*arr.Elements))
+		for i, el := range *arr.Elements {
+			elements[i] = wrapAny(copyOrRef(el), param.T)
+		}
+		arr = &Array{Elements: &elements}
 	}
+	scope.set(param.Name, arr)
 	return scope
 }
 
-func (e *Evaluator) evalReturn(scope *scope, ret *parser.Return) Value {
+// wrapAny wraps val in *Any if it is stored in a variable of type t,
+// which is `any`, so that values of other types can be assigned to it.
+func wrapAny(val Value, t *parser.Type) Value {
+	if t == parser.ANY_TYPE && val.Type() != ANY {
+		return &Any{Val: val}
+	}
+	return val
+}
+
+func (e *Evaluator) evalReturn(scope *scope, ret *parser.Return) (Value, error) {
 	if ret.Value == nil {
-		return &ReturnValue{}
+		return &ReturnValue{}, nil
 	}
-	val := e.Eval(scope, ret.Value)
-	if isError(val) {
-		return val
+	val, err := e.Eval(scope, ret.Value)
+	if err != nil {
+		return nil, err
 	}
-	return &ReturnValue{Val: val}
+	return &ReturnValue{Val: val}, nil
 }
 
 func (e *Evaluator) evalBreak(scope *scope, ret *parser.Break) Value {
 	return &Break{}
 }
 
-func (e *Evaluator) evalIf(scope *scope, i *parser.If) Value {
-	val, ok := e.evalConditionalBlock(scope, i.IfBlock)
-	if ok || isError(val) {
-		return val
+func (e *Evaluator) evalIf(scope *scope, i *parser.If) (Value, error) {
+	val, ok, err := e.evalConditionalBlock(scope, i.IfBlock)
+	if ok || err != nil {
+		return val, err
 	}
 	for _, elseif := range i.ElseIfBlocks {
-		val, ok := e.evalConditionalBlock(scope, elseif)
-		if ok || isError(val) {
-			return val
+		val, ok, err := e.evalConditionalBlock(scope, elseif)
+		if ok || err != nil {
+			return val, err
 		}
 	}
 	if i.Else != nil {
 		return e.Eval(newInnerScope(scope), i.Else)
 	}
-	return nil
+	return nil, nil
 }
 
-func (e *Evaluator) evalWhile(scope *scope, w *parser.While) Value {
+func (e *Evaluator) evalWhile(scope *scope, w *parser.While) (Value, error) {
 	whileBlock := &w.ConditionalBlock
-	val, ok := e.evalConditionalBlock(scope, whileBlock)
-	for ok && !isError(val) && !isReturn(val) && !isBreak(val) {
-		val, ok = e.evalConditionalBlock(scope, whileBlock)
+	val, ok, err := e.evalConditionalBlock(scope, whileBlock)
+	for ok && err == nil && !isReturn(val) && !isBreak(val) {
+		val, ok, err = e.evalConditionalBlock(scope, whileBlock)
 	}
-	return val
+	return val, err
 }
 
-func (e *Evaluator) evalFor(scope *scope, f *parser.For) Value {
+func (e *Evaluator) evalFor(scope *scope, f *parser.For) (Value, error) {
 	scope = newInnerScope(scope)
 	r, err := e.newRange(scope, f)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	for r.next() {
-		val := e.Eval(scope, f.Block)
-		if isError(val) || isBreak(val) || isReturn(val) {
-			return val
+		val, err := e.Eval(scope, f.Block)
+		if err != nil {
+			return nil, err
+		}
+		if isBreak(val) || isReturn(val) {
+			return val, nil
 		}
 	}
-	return nil
+	return nil, nil
 }
 
-func (e *Evaluator) newRange(scope *scope, f *parser.For) (ranger, Value) {
+func (e *Evaluator) newRange(scope *scope, f *parser.For) (ranger, error) {
 	if r, ok := f.Range.(*parser.StepRange); ok {
 		return e.newStepRange(scope, r, f.LoopVar)
 	}
-	rangeVal := e.Eval(scope, f.Range)
-	if isError(rangeVal) {
-		return nil, rangeVal
+	rangeVal, err := e.Eval(scope, f.Range)
+	if err != nil {
+		return nil, err
 	}
 
 	switch v := rangeVal.(type) {
@@ -257,24 +448,24 @@ func (e *Evaluator) newRange(scope *scope, f *parser.For) (ranger, Value) {
 		m := &mapRange{loopVar: loopVar, mapVal: v, cur: 0, order: order}
 		return m, nil
 	}
-	return nil, newError("cannot create range for " + f.Range.String())
+	return nil, newError(ErrInternal, "cannot create range for "+f.Range.String())
 }
 
-func (e *Evaluator) newStepRange(scope *scope, r *parser.StepRange, loopVar *parser.Var) (ranger, Value) {
-	start, errValue := e.numValWithDefault(scope, r.Start, 0.0)
-	if errValue != nil {
-		return nil, errValue
+func (e *Evaluator) newStepRange(scope *scope, r *parser.StepRange, loopVar *parser.Var) (ranger, error) {
+	start, err := e.numValWithDefault(scope, r.Start, 0.0)
+	if err != nil {
+		return nil, err
 	}
-	stop, errValue := e.numVal(scope, r.Stop)
-	if errValue != nil {
-		return nil, errValue
+	stop, err := e.numVal(scope, r.Stop)
+	if err != nil {
+		return nil, err
 	}
-	step, errValue := e.numValWithDefault(scope, r.Step, 1.0)
-	if errValue != nil {
-		return nil, errValue
+	step, err := e.numValWithDefault(scope, r.Step, 1.0)
+	if err != nil {
+		return nil, err
 	}
 	if step == 0 {
-		return nil, newError("step cannot by 0, infinite loop")
+		return nil, e.annotate(newError(ErrRange, "step cannot by 0, infinite loop"), r)
 	}
 	loopVarVal := &Num{}
 	scope.set(loopVar.Name, loopVarVal)
@@ -288,100 +479,101 @@ func (e *Evaluator) newStepRange(scope *scope, r *parser.StepRange, loopVar *par
 	return ranger, nil
 }
 
-func (e *Evaluator) numVal(scope *scope, n parser.Node) (float64, Value) {
-	v := e.Eval(scope, n)
-	if isError(v) {
-		return 0, v
+func (e *Evaluator) numVal(scope *scope, n parser.Node) (float64, error) {
+	v, err := e.Eval(scope, n)
+	if err != nil {
+		return 0, err
 	}
 	numVal, ok := v.(*Num)
 	if !ok {
-		return 0, newError("expected number, found " + v.String())
+		return 0, e.annotate(newError(ErrInternal, "expected number, found "+v.String()), n)
 	}
 	return numVal.Val, nil
 }
 
-func (e *Evaluator) numValWithDefault(scope *scope, n parser.Node, defaultVal float64) (float64, Value) {
+func (e *Evaluator) numValWithDefault(scope *scope, n parser.Node, defaultVal float64) (float64, error) {
 	if n == nil {
 		return defaultVal, nil
 	}
 	return e.numVal(scope, n)
 }
 
-func (e *Evaluator) evalConditionalBlock(scope *scope, condBlock *parser.ConditionalBlock) (Value, bool) {
+func (e *Evaluator) evalConditionalBlock(scope *scope, condBlock *parser.ConditionalBlock) (Value, bool, error) {
 	scope = newInnerScope(scope)
-	cond := e.Eval(scope, condBlock.Condition)
-	if isError(cond) {
-		return cond, false
+	cond, err := e.Eval(scope, condBlock.Condition)
+	if err != nil {
+		return nil, false, err
 	}
 	boolCond, ok := cond.(*Bool)
 	if !ok {
-		return newError("conditional not a bool"), false
+		return nil, false, e.annotate(newError(ErrInternal, "conditional not a bool"), condBlock.Condition)
 	}
 	if boolCond.Val {
-		return e.Eval(scope, condBlock.Block), true
+		val, err := e.Eval(scope, condBlock.Block)
+		return val, true, err
 	}
-	return nil, false
+	return nil, false, nil
 }
 
-func (e *Evaluator) evalBlockStatment(scope *scope, block *parser.BlockStatement) Value {
+func (e *Evaluator) evalBlockStatment(scope *scope, block *parser.BlockStatement) (Value, error) {
 	return e.evalStatments(scope, block.Statements)
 }
 
-func (e *Evaluator) evalVar(scope *scope, v *parser.Var) Value {
+func (e *Evaluator) evalVar(scope *scope, v *parser.Var) (Value, error) {
 	if val, ok := scope.get(v.Name); ok {
-		return val
+		return val, nil
 	}
-	return newError("cannot find variable " + v.Name)
+	return nil, newError(ErrInternal, "cannot find variable "+v.Name)
 }
 
-func (e *Evaluator) evalExprList(scope *scope, terms []parser.Node) []Value {
+func (e *Evaluator) evalExprList(scope *scope, terms []parser.Node) ([]Value, error) {
 	result := make([]Value, len(terms))
 
 	for i, t := range terms {
-		evaluated := e.Eval(scope, t)
-		if isError(evaluated) {
-			return []Value{evaluated}
+		evaluated, err := e.Eval(scope, t)
+		if err != nil {
+			return nil, err
 		}
 		result[i] = copyOrRef(evaluated)
 	}
 
-	return result
+	return result, nil
 }
 
-func (e *Evaluator) evalUnaryExpr(scope *scope, expr *parser.UnaryExpression) Value {
-	right := e.Eval(scope, expr.Right)
-	if isError(right) {
-		return right
+func (e *Evaluator) evalUnaryExpr(scope *scope, expr *parser.UnaryExpression) (Value, error) {
+	right, err := e.Eval(scope, expr.Right)
+	if err != nil {
+		return nil, err
 	}
 	op := expr.Op
 	switch right := right.(type) {
 	case *Num:
 		if op == parser.OP_MINUS {
-			return &Num{Val: -right.Val}
+			return &Num{Val: -right.Val}, nil
 		}
 	case *Bool:
 		if op == parser.OP_BANG {
-			return &Bool{Val: !right.Val}
+			return &Bool{Val: !right.Val}, nil
 		}
 	}
-	return newError("unknown unary operation: " + expr.String())
+	return nil, newError(ErrInternal, "unknown unary operation: "+expr.String())
 }
 
-func (e *Evaluator) evalBinaryExpr(scope *scope, expr *parser.BinaryExpression) Value {
-	left := e.Eval(scope, expr.Left)
-	if isError(left) {
-		return left
+func (e *Evaluator) evalBinaryExpr(scope *scope, expr *parser.BinaryExpression) (Value, error) {
+	left, err := e.Eval(scope, expr.Left)
+	if err != nil {
+		return nil, err
 	}
-	right := e.Eval(scope, expr.Right)
-	if isError(right) {
-		return right
+	right, err := e.Eval(scope, expr.Right)
+	if err != nil {
+		return nil, err
 	}
 	op := expr.Op
 	if op == parser.OP_EQ {
-		return &Bool{Val: left.Equals(right)}
+		return &Bool{Val: left.Equals(right)}, nil
 	}
 	if op == parser.OP_NOT_EQ {
-		return &Bool{Val: !left.Equals(right)}
+		return &Bool{Val: !left.Equals(right)}, nil
 	}
 	switch l := left.(type) {
 	case *Num:
@@ -393,68 +585,70 @@ func (e *Evaluator) evalBinaryExpr(scope *scope, expr *parser.BinaryExpression)
 	case *Array:
 		return evalBinaryArrayExpr(op, l, right.(*Array))
 	}
-	return newError("unknown binary operation: " + expr.String())
+	return nil, newError(ErrInternal, "unknown binary operation: "+expr.String())
 }
 
-func evalBinaryNumExpr(op parser.Operator, left, right *Num) Value {
+func evalBinaryNumExpr(op parser.Operator, left, right *Num) (Value, error) {
 	switch op {
 	case parser.OP_PLUS:
-		return &Num{Val: left.Val + right.Val}
+		return &Num{Val: left.Val + right.Val}, nil
 	case parser.OP_MINUS:
-		return &Num{Val: left.Val - right.Val}
+		return &Num{Val: left.Val - right.Val}, nil
 	case parser.OP_ASTERISK:
-		return &Num{Val: left.Val * right.Val}
+		return &Num{Val: left.Val * right.Val}, nil
 	case parser.OP_SLASH:
-		return &Num{Val: left.Val / right.Val}
+		return &Num{Val: left.Val / right.Val}, nil
+	case parser.OP_PERCENT:
+		return &Num{Val: math.Mod(left.Val, right.Val)}, nil
 	case parser.OP_GT:
-		return &Bool{Val: left.Val > right.Val}
+		return &Bool{Val: left.Val > right.Val}, nil
 	case parser.OP_LT:
-		return &Bool{Val: left.Val < right.Val}
+		return &Bool{Val: left.Val < right.Val}, nil
 	case parser.OP_GTEQ:
-		return &Bool{Val: left.Val >= right.Val}
+		return &Bool{Val: left.Val >= right.Val}, nil
 	case parser.OP_LTEQ:
-		return &Bool{Val: left.Val <= right.Val}
+		return &Bool{Val: left.Val <= right.Val}, nil
 	}
-	return newError("unknown num operation: " + op.String())
+	return nil, newError(ErrInternal, "unknown num operation: "+op.String())
 }
 
-func evalBinaryStringExpr(op parser.Operator, left, right *String) Value {
+func evalBinaryStringExpr(op parser.Operator, left, right *String) (Value, error) {
 	switch op {
 	case parser.OP_PLUS:
-		return &String{Val: left.Val + right.Val}
+		return &String{Val: left.Val + right.Val}, nil
 	case parser.OP_GT:
-		return &Bool{left.Val > right.Val}
+		return &Bool{left.Val > right.Val}, nil
 	case parser.OP_LT:
-		return &Bool{left.Val < right.Val}
+		return &Bool{left.Val < right.Val}, nil
 	case parser.OP_GTEQ:
-		return &Bool{left.Val >= right.Val}
+		return &Bool{left.Val >= right.Val}, nil
 	case parser.OP_LTEQ:
-		return &Bool{left.Val <= right.Val}
+		return &Bool{left.Val <= right.Val}, nil
 	}
-	return newError("unknown string operation: " + op.String())
+	return nil, newError(ErrInternal, "unknown string operation: "+op.String())
 }
 
-func evalBinaryBoolExpr(op parser.Operator, left, right *Bool) Value {
+func evalBinaryBoolExpr(op parser.Operator, left, right *Bool) (Value, error) {
 	switch op {
 	case parser.OP_AND:
-		return &Bool{Val: left.Val && right.Val}
+		return &Bool{Val: left.Val && right.Val}, nil
 	case parser.OP_OR:
-		return &Bool{Val: left.Val || right.Val}
+		return &Bool{Val: left.Val || right.Val}, nil
 	}
-	return newError("unknown bool operation: " + op.String())
+	return nil, newError(ErrInternal, "unknown bool operation: "+op.String())
 }
 
-func evalBinaryArrayExpr(op parser.Operator, left, right *Array) Value {
+func evalBinaryArrayExpr(op parser.Operator, left, right *Array) (Value, error) {
 	if op != parser.OP_PLUS {
-		return newError("unknown array operation: " + op.String())
+		return nil, newError(ErrInternal, "unknown array operation: "+op.String())
 	}
 	result := left.Copy()
 	rightElemnts := *right.Copy().Elements
 	*result.Elements = append(*result.Elements, rightElemnts...)
-	return result
+	return result, nil
 }
 
-func (e *Evaluator) evalTarget(scope *scope, node parser.Node) Value {
+func (e *Evaluator) evalTarget(scope *scope, node parser.Node) (Value, error) {
 	switch n := node.(type) {
 	case *parser.Var:
 		return e.evalVar(scope, n)
@@ -463,45 +657,47 @@ func (e *Evaluator) evalTarget(scope *scope, node parser.Node) Value {
 	case *parser.DotExpression:
 		return e.evalDotExpr(scope, n, true /* forAssign */)
 	}
-	return newError("invalid assignment target " + node.String())
+	return nil, newError(ErrInternal, "invalid assignment target "+node.String())
 }
 
-func (e *Evaluator) evalIndexExpr(scope *scope, expr *parser.IndexExpression, forAssign bool) Value {
-	left := e.Eval(scope, expr.Left)
-	if isError(left) {
-		return left
+func (e *Evaluator) evalIndexExpr(scope *scope, expr *parser.IndexExpression, forAssign bool) (Value, error) {
+	left, err := e.Eval(scope, expr.Left)
+	if err != nil {
+		return nil, err
 	}
-	index := e.Eval(scope, expr.Index)
-	if isError(index) {
-		return index
+	index, err := e.Eval(scope, expr.Index)
+	if err != nil {
+		return nil, err
 	}
 
 	switch l := left.(type) {
 	case *Array:
-		return l.Index(index)
+		val, err := l.Index(index)
+		return e.recoverError(val, err, expr.Type())
 	case *String:
-		return l.Index(index)
+		val, err := l.Index(index)
+		return e.recoverError(val, err, expr.Type())
 	case *Map:
 		strIndex, ok := index.(*String)
 		if !ok {
-			return newError("expected string for map index, found " + index.String())
+			return nil, newError(ErrInternal, "expected string for map index, found "+index.String())
 		}
 		if forAssign {
 			l.InsertKey(strIndex.Val, expr.Type())
 		}
 		return l.Get(strIndex.Val)
 	}
-	return nil
+	return nil, newError(ErrInternal, "cannot index "+left.String())
 }
 
-func (e *Evaluator) evalDotExpr(scope *scope, expr *parser.DotExpression, forAssign bool) Value {
-	left := e.Eval(scope, expr.Left)
-	if isError(left) {
-		return left
+func (e *Evaluator) evalDotExpr(scope *scope, expr *parser.DotExpression, forAssign bool) (Value, error) {
+	left, err := e.Eval(scope, expr.Left)
+	if err != nil {
+		return nil, err
 	}
 	m, ok := left.(*Map)
 	if !ok {
-		return newError("expected map before '.', found " + left.String())
+		return nil, newError(ErrInternal, "expected map before '.', found "+left.String())
 	}
 	if forAssign {
 		m.InsertKey(expr.Key, expr.Type())
@@ -509,24 +705,48 @@ func (e *Evaluator) evalDotExpr(scope *scope, expr *parser.DotExpression, forAss
 	return m.Get(expr.Key)
 }
 
-func (e *Evaluator) evalSliceExpr(scope *scope, expr *parser.SliceExpression) Value {
-	left := e.Eval(scope, expr.Left)
-	if isError(left) {
-		return left
+func (e *Evaluator) evalTypeAssertion(scope *scope, ta *parser.TypeAssertion) (Value, error) {
+	val, err := e.Eval(scope, ta.Left)
+	if err != nil {
+		return nil, err
+	}
+	val = unwrapAny(val)
+	if !hasType(val, ta.T) {
+		msg := "type assertion failed: expected " + ta.T.Format() + ", found " + typeString(val)
+		return e.recoverError(nil, newRecoverableError(ErrAssertion, msg), ta.T)
 	}
-	start := e.Eval(scope, expr.Start)
-	if isError(start) {
-		return start
+	return e.recoverError(val, nil, ta.T)
+}
+
+func (e *Evaluator) evalSliceExpr(scope *scope, expr *parser.SliceExpression) (Value, error) {
+	left, err := e.Eval(scope, expr.Left)
+	if err != nil {
+		return nil, err
 	}
-	end := e.Eval(scope, expr.End)
-	if isError(end) {
-		return end
+	start, err := e.evalOptional(scope, expr.Start)
+	if err != nil {
+		return nil, err
+	}
+	end, err := e.evalOptional(scope, expr.End)
+	if err != nil {
+		return nil, err
 	}
 	switch left := left.(type) {
 	case *Array:
-		return left.Slice(start, end)
+		val, err := left.Slice(start, end)
+		return e.recoverError(val, err, expr.Type())
 	case *String:
-		return left.Slice(start, end)
+		val, err := left.Slice(start, end)
+		return e.recoverError(val, err, expr.Type())
+	}
+	return nil, newError(ErrInternal, "cannot slice "+left.String())
+}
+
+// evalOptional evaluates node, which may be nil, e.g. the start index
+// of the slice expression `a[:2]`.
+func (e *Evaluator) evalOptional(scope *scope, node parser.Node) (Value, error) {
+	if node == nil {
+		return nil, nil
 	}
-	return newError("cannot slice " + left.String())
+	return e.Eval(scope, node)
 }
@@ -2,8 +2,14 @@ package evaluator
 
 import (
 	"bytes"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"foxygo.at/evy/pkg/assert"
 )
@@ -292,6 +298,10 @@ func TestExpr(t *testing.T) {
 		"a := 1 + 2 * 2":                    "5",
 		"a := (1 + 2) * 2":                  "6",
 		"a := (1 + 2) / 2":                  "1.5",
+		"a := 7 % 3":                        "1",
+		"a := -7 % 3":                       "-1",
+		"a := 7.5 % 2":                      "1.5",
+		"a := 1 + 7 % 3 * 2":                "3",
 		"a := (1 + 2) / 2 > 1":              "true",
 		"a := (1 + 2) / 2 > 1 and 2 == 2*2": "false",
 		"a := (1 + 2) / 2 < 1 or 2 == 2*2":  "false",
@@ -396,10 +406,17 @@ func TestDoubleIndex(t *testing.T) {
 func TestIndexErr(t *testing.T) {
 	tests := map[string]string{
 		// x := ["a","b","c"]; x = "abc"
-		"print x[3]":  "ERROR: index 3 out of bounds, should be between -3 and 2",
-		"print x[-4]": "ERROR: index -4 out of bounds, should be between -3 and 2",
+		"print x[3] errnum error":  " 3 index 3 out of bounds, should be between -3 and 2\n",
+		"print x[-4] errnum error": " 3 index -4 out of bounds, should be between -3 and 2\n",
+		`y := x[1:4]
+		print (len y) errnum error`: "0 3 index 4 out of bounds, should be between -3 and 2\n",
+		`y := x[2:1]
+		print (len y) errnum error`: "0 3 invalid slice indices: 2 > 1\n",
+		`y := x[3]
+		y = x[0]
+		print y errnum error`: "a 0 \n",
 		`m := {}
-		print m[x[1]]`: "ERROR: no value for key b",
+		print m[x[1]]`: "line 3 column 10: no value for key b",
 	}
 	for in, want := range tests {
 		in, want := in, want
@@ -470,7 +487,221 @@ print m.missing_index
 	b := bytes.Buffer{}
 	fn := func(s string) { b.WriteString(s) }
 	Run(in, fn)
-	want := "ERROR: no value for key missing_index"
+	want := "line 3 column 8: no value for key missing_index"
+	assert.Equal(t, want, b.String())
+}
+
+func TestTypeAssertion(t *testing.T) {
+	tests := map[string]string{
+		`x = 1
+		print x.(num)+1`: "2",
+		`x = "abc"
+		print x.(string)`: "abc",
+		`x = [1 2]
+		a := x.([]num)
+		a[0] = 3
+		print a x`: "[3 2] [3 2]",
+		`func f a:any
+			arr := a.([]num)
+			append arr 3
+		end
+		x = [1 2]
+		f x
+		print x`: "[1 2 3]",
+		`x = [[1 2] [3]]
+		print x.([][]num)`: "[[1 2] [3]]",
+		`x = [1 "a"]
+		print x.([]any)`: "[1 a]",
+		`x = {a:[true] b:[]}
+		print x.({}[]bool)`: "{a:[true] b:[]}",
+		`m:{}any
+		m.a = 1
+		x = m
+		print x.({}any)`: "{a:1}",
+	}
+	for in, want := range tests {
+		in, want := in, want
+		input := "x:any\n" + in
+		t.Run(input, func(t *testing.T) {
+			b := bytes.Buffer{}
+			fn := func(s string) { b.WriteString(s) }
+			Run(input, fn)
+			assert.Equal(t, want+"\n", b.String())
+		})
+	}
+}
+
+func TestTypeAssertionErr(t *testing.T) {
+	tests := map[string]string{
+		`x = 1
+		print x.(string) errnum error`: " 4 type assertion failed: expected string, found num",
+		`x = [1 "a"]
+		print x.([]num) errnum error`: "[] 4 type assertion failed: expected num[], found array",
+		`x = [[1 2] [3 "a"]]
+		print x.([][]num) errnum error`: "[] 4 type assertion failed: expected num[][], found array",
+		`x = {a:1}
+		print x.([]num) errnum error`: "[] 4 type assertion failed: expected num[], found map",
+		`x = 1
+		n := x.(bool)
+		n = !n
+		print n x.(num) errnum error`: "true 1 0 ",
+	}
+	for in, want := range tests {
+		in, want := in, want
+		input := "x:any\n" + in
+		t.Run(input, func(t *testing.T) {
+			b := bytes.Buffer{}
+			fn := func(s string) { b.WriteString(s) }
+			Run(input, fn)
+			assert.Equal(t, want+"\n", b.String())
+		})
+	}
+}
+
+func TestReflect(t *testing.T) {
+	tests := map[string]string{
+		`print (reflect 1)`:                          "{type:num}",
+		`print (reflect "abc")`:                      "{type:string}",
+		`print (reflect true)`:                       "{type:bool}",
+		`print (reflect [1 2])`:                      "{type:array sub:{type:num}}",
+		`print (reflect [[1 2] [3 4]])`:              "{type:array sub:{type:array sub:{type:num}}}",
+		`print (reflect [])`:                         "{type:array sub:{type:any}}",
+		`print (reflect [1 "a"])`:                    "{type:array sub:{type:any}}",
+		`print (reflect {a:"x"})`:                    "{type:map sub:{type:string}}",
+		`print (reflect {a:[1] b:[true]})`:           "{type:map sub:{type:any}}",
+		`print (reflect {})`:                         "{type:map sub:{type:any}}",
+		`print ((reflect "abc") == {type:"string"})`: "true",
+		`print ((reflect "abc") == {type:"num"})`:    "false",
+		`x:any
+		x = [1]
+		print ((reflect x) == {type:"array" sub:{type:"num"}})`: "true",
+		`x:any
+		x = "abc"
+		print (x == "abc") (x != "abc")`: "true false",
+		`m:{}any
+		m.a = 1
+		print (m == {a:1})`: "true",
+		`r := reflect [1]
+		r.type = 5
+		r.sub = [true]
+		print r`: "{type:5 sub:[true]}",
+	}
+	for in, want := range tests {
+		in, want := in, want
+		t.Run(in, func(t *testing.T) {
+			b := bytes.Buffer{}
+			fn := func(s string) { b.WriteString(s) }
+			Run(in, fn)
+			assert.Equal(t, want+"\n", b.String())
+		})
+	}
+}
+
+func TestSpread(t *testing.T) {
+	prog := `
+func addmany:num arr:num...
+	result := 0
+	for x := range arr
+		result = result + x
+	end
+	return result
+end
+
+func zero arr:num...
+	arr[0] = 0
+end
+
+func countany:num arr:any...
+	return len arr
+end
+
+print (addmany 1 2 3)
+arr := [4 5 6]
+print (addmany arr...)
+print (addmany []...)
+print arr...
+print (countany arr...)
+print (sprint ["a" "b"]...)
+zero arr...
+print arr
+`
+	b := bytes.Buffer{}
+	fn := func(s string) { b.WriteString(s) }
+	Run(prog, fn)
+	want := `
+6
+15
+0
+4 5 6
+3
+a b
+[0 5 6]
+`[1:]
+	assert.Equal(t, want, b.String())
+}
+
+func TestAnyParams(t *testing.T) {
+	prog := `
+func setany arr:any...
+	arr[0] = "x"
+	print arr
+end
+
+func set a:any
+	a = "y"
+	print a
+end
+
+nums := [1 2]
+setany nums...
+print nums
+anys:[]any
+anys = [1 true]
+setany anys...
+print anys
+setany 3 4
+n := 5
+set n
+print n
+`
+	b := bytes.Buffer{}
+	fn := func(s string) { b.WriteString(s) }
+	Run(prog, fn)
+	want := `
+[x 2]
+[1 2]
+[x true]
+[x true]
+[x 4]
+y
+5
+`[1:]
+	assert.Equal(t, want, b.String())
+}
+
+func TestAppendAny(t *testing.T) {
+	prog := `
+arr:[]any
+append arr 1
+prepend arr "a"
+append arr [2]
+s := arr[0].(string)
+n := arr[1].(num)
+nums := arr[2].([]num)
+nums[0] = 3
+print s n nums arr
+arr[0] = 0
+arr[1] = "b"
+arr[2] = true
+print arr
+`
+	b := bytes.Buffer{}
+	fn := func(s string) { b.WriteString(s) }
+	Run(prog, fn)
+	want := `
+a 1 [3] [a 1 [3]]
+[0 b true]
+`[1:]
 	assert.Equal(t, want, b.String())
 }
 
@@ -708,6 +939,40 @@ end
 	}
 }
 
+func TestForUnicode(t *testing.T) {
+	prog := `
+s := "Hallöchen 🌏"
+print (len s) s[6] s[-1] s[5:8]
+n := 0
+for ch := range s
+	if ch != s[n]
+		print "💣" ch s[n]
+	end
+	n = n + 1
+end
+print n
+for ch := range "é🦊"
+	print ch ch[0] (len ch)
+end
+for k := range {ü:1 ab:2}
+	print k k[0] (len k)
+end
+`
+	b := bytes.Buffer{}
+	fn := func(s string) { b.WriteString(s) }
+	Run(prog, fn)
+	want := []string{
+		"11 h 🌏 che",
+		"11",
+		"é é 1",
+		"🦊 🦊 1",
+		"ü ü 1",
+		"ab a 2",
+		"",
+	}
+	assert.Equal(t, strings.Join(want, "\n"), b.String())
+}
+
 func TestMap(t *testing.T) {
 	prog := `
 m1 := {a:1 b:2}
@@ -856,6 +1121,152 @@ print (join [1 true "x"] ", ")
 	assert.Equal(t, want, b.String())
 }
 
+func TestPrintVariants(t *testing.T) {
+	prog := `
+m := {a:1 b:"x"}
+m["a b"] = 2
+arr:[]any
+arr = [1 "a" true [2 "b"] {c:"d"}]
+print "abc" 123 arr
+prints "abc" 123
+prints "|"
+print
+printq "abc" 123 arr m
+s := sprints "a" 1 true
+q := sprintq "a" 1 (sprint "b" 2)
+print s q (len q)
+x:any
+x = "any"
+printq x [x]`
+	b := bytes.Buffer{}
+	fn := func(s string) { b.WriteString(s) }
+	Run(prog, fn)
+	want := []string{
+		`abc 123 [1 a true [2 b] {c:d}]`,
+		`abc123|`,
+		`"abc" 123 [1 "a" true [2 "b"] {c:"d"}] {a:1 b:"x" "a b":2}`,
+		`a1true "a" 1 "b 2" 11`,
+		`"any" ["any"]`,
+		"",
+	}
+	assert.Equal(t, strings.Join(want, "\n"), b.String())
+}
+
+func TestStringEscapes(t *testing.T) {
+	prog := `
+s := "a\tb\n\"c\"\\\u{1F98A}"
+print s
+printq s
+print (len s) (len "") (len "\u{e9}")
+print ("x\u{41}y" == "xAy")`
+	b := bytes.Buffer{}
+	fn := func(s string) { b.WriteString(s) }
+	Run(prog, fn)
+	want := []string{
+		"a\tb",
+		`"c"\🦊`,
+		`"a\tb\n\"c\"\\🦊"`,
+		"9 0 1",
+		"true",
+		"",
+	}
+	assert.Equal(t, strings.Join(want, "\n"), b.String())
+}
+
+func TestStringBuiltins(t *testing.T) {
+	tests := map[string]string{
+		`upper "Hallöchen"`:            `"HALLÖCHEN"`,
+		`lower "HALLÖCHEN"`:            `"hallöchen"`,
+		`trim "  abc \t" " \t"`:        `"abc"`,
+		`trim "xxaxbxx" "x"`:           `"axb"`,
+		`trim "🦊a🦊" "🦊"`:               `"a"`,
+		`index "hello" "l"`:            "2",
+		`index "hello" "x"`:            "-1",
+		`index "hello" ""`:             "0",
+		`index "🦊🐻fox" "fox"`:          "2",
+		`replace "banana" "a" "o"`:     `"bonono"`,
+		`replace "🦊🦊" "🦊" "fox"`:       `"foxfox"`,
+		`startswith "hello" "he"`:      "true",
+		`startswith "hello" "lo"`:      "false",
+		`endswith "hello" "lo"`:        "true",
+		`endswith "hello" "he"`:        "false",
+		`repeat "ab" 3`:                `"ababab"`,
+		`split "a,b,,c" ","`:           `["a" "b" "" "c"]`,
+		`split "Hallö 🌏" ""`:           `["H" "a" "l" "l" "ö" " " "🌏"]`,
+		`split "" ""`:                  `[]`,
+		`fields " hi  there\t🌏\n"`:     `["hi" "there" "🌏"]`,
+		`fields " "`:                   `[]`,
+		`repeat "ab" 0`:                `""`,
+		`(upper (repeat "é" 2))`:       `"ÉÉ"`,
+		`"🦊🐻fox"[(index "🦊🐻fox" "🐻")]`: `"🐻"`,
+	}
+	for in, want := range tests {
+		in, want := in, want
+		t.Run(in, func(t *testing.T) {
+			in = "printq (" + in + ")"
+			b := bytes.Buffer{}
+			fn := func(s string) { b.WriteString(s) }
+			Run(in, fn)
+			assert.Equal(t, want+"\n", b.String())
+		})
+	}
+}
+
+func TestRepeatErr(t *testing.T) {
+	tests := map[string]string{
+		`print (repeat "a" -1)`:                   "line 1 column 8: repeat: n must not be negative, found -1",
+		`print (repeat "a" 1.5)`:                  "line 1 column 8: repeat: n must be a whole number, found 1.5",
+		`print (repeat "ab" 5000000000000000000)`: "line 1 column 8: repeat: result must not be longer than 16777216 bytes, found n 5000000000000000000",
+		`print (repeat "ab" 8388609)`:             "line 1 column 8: repeat: result must not be longer than 16777216 bytes, found n 8388609",
+	}
+	for prog, want := range tests {
+		rt := Runtime{Print: func(s string) {}}
+		err := RunWithBuiltinsErr(prog, DefaultBuiltins(rt))
+		assert.Equal(t, want, err.Error(), prog)
+	}
+	b := bytes.Buffer{}
+	Run(`print (len (repeat "ab" 8388608)) (repeat "" 5000000000000000000)"|"`, func(s string) { b.WriteString(s) })
+	assert.Equal(t, "16777216  |\n", b.String())
+}
+
+func TestLiteral(t *testing.T) {
+	tests := map[Value]string{
+		&String{Val: "a\"b\\c"}:      `"a\"b\\c"`,
+		&String{Val: "tab\tnl\n"}:    `"tab\tnl\n"`,
+		&String{Val: "bell\a🦊"}:      `"bell\u{7}🦊"`,
+		&Num{Val: -1.5}:              "-1.5",
+		&Bool{Val: true}:             "true",
+		&Any{Val: &String{Val: "x"}}: `"x"`,
+		&Map{Pairs: map[string]Value{"if": &Num{}, "a1": &Num{}, "1a": &Num{}}, Order: &[]string{"if", "a1", "1a"}}: `{"if":0 a1:0 "1a":0}`,
+		&Num{Val: math.NaN()}:   "(0/0)",
+		&Num{Val: math.Inf(1)}:  "(1/0)",
+		&Num{Val: math.Inf(-1)}: "(-1/0)",
+	}
+	for val, want := range tests {
+		assert.Equal(t, want, val.Literal())
+	}
+}
+
+// TestLiteralRoundTrip checks that printq output can be pasted back
+// into evy source code and evaluates to an equal value.
+func TestLiteralRoundTrip(t *testing.T) {
+	prog := `
+m:{}any
+m["a b"] = [1 "x\ty" true]
+m["if"] = {n:0/0 "1a":-1/0}
+m.c = 1/0
+m[""] = [[] {}]
+printq m`
+	b := bytes.Buffer{}
+	Run(prog, func(s string) { b.WriteString(s) })
+	lit := b.String()
+	assert.Equal(t, `{"a b":[1 "x\ty" true] "if":{n:(0/0) "1a":(-1/0)} c:(1/0) "":[[] {}]}`+"\n", lit)
+
+	b.Reset()
+	Run("m := "+lit+"printq m", func(s string) { b.WriteString(s) })
+	assert.Equal(t, lit, b.String())
+}
+
 func TestSprint(t *testing.T) {
 	prog := `
 s := sprint 1 [2] "x"
@@ -957,6 +1368,768 @@ print n a m`
 	assert.Equal(t, want, b.String())
 }
 
+type testEvents struct {
+	events  []Event
+	names   []string
+	stopped bool
+}
+
+func (te *testEvents) Start(names []string) <-chan Event {
+	te.names = names
+	ch := make(chan Event, len(te.events))
+	for _, ev := range te.events {
+		ch <- ev
+	}
+	close(ch)
+	return ch
+}
+
+func (te *testEvents) Stop() {
+	te.stopped = true
+}
+
+func TestEventHandler(t *testing.T) {
+	prog := `
+n := 0
+print "start"
+on key_press
+	n = n + 1
+	print "key" n key
+end
+on mouse_down
+	print "down" n mouse_x mouse_y
+end
+print "end"`
+	b := bytes.Buffer{}
+	rt := Runtime{
+		Print: func(s string) { b.WriteString(s) },
+		Events: &testEvents{events: []Event{
+			{Name: "key_press", Params: []Value{&String{Val: "a"}}},
+			{Name: "mouse_down", Params: []Value{&Num{Val: 1}, &Num{Val: 2.5}}},
+			{Name: "frame"},     // no handler, ignored
+			{Name: "key_press"}, // missing params are zero values
+		}},
+	}
+	RunWithBuiltins(prog, DefaultBuiltins(rt))
+	want := `
+start
+end
+key 1 a
+down 1 1 2.5
+key 2 
+`[1:]
+	assert.Equal(t, want, b.String())
+	te := rt.Events.(*testEvents)
+	assert.Equal(t, []string{"key_press", "mouse_down"}, te.names)
+	assert.Equal(t, false, te.stopped)
+}
+
+func TestEventHandlerErr(t *testing.T) {
+	prog := `
+on key_press
+	m := {a:1}
+	print m.b
+end`
+	b := bytes.Buffer{}
+	te := &testEvents{events: []Event{{Name: "key_press"}, {Name: "key_press"}}}
+	rt := Runtime{
+		Print:  func(s string) { b.WriteString(s) },
+		Events: te,
+	}
+	RunWithBuiltins(prog, DefaultBuiltins(rt))
+	assert.Equal(t, "line 4 column 9: no value for key b", b.String())
+	assert.Equal(t, true, te.stopped)
+}
+
+func TestFrame(t *testing.T) {
+	prog := `
+on frame
+	print "frame" elapsed
+end`
+	b := bytes.Buffer{}
+	rt := Runtime{
+		Print:  func(s string) { b.WriteString(s) },
+		Ticker: NewHeadlessTicker(3, 20*time.Millisecond),
+	}
+	RunWithBuiltins(prog, DefaultBuiltins(rt))
+	assert.Equal(t, "frame 0\nframe 0.02\nframe 0.04\n", b.String())
+}
+
+func TestFrameWithEvents(t *testing.T) {
+	prog := `
+on frame
+	print "frame"
+end
+on key_press
+	print "key" key
+end`
+	b := bytes.Buffer{}
+	rt := Runtime{
+		Print:  func(s string) { b.WriteString(s) },
+		Events: &testEvents{events: []Event{{Name: "key_press", Params: []Value{&String{Val: "a"}}}}},
+		Ticker: NewHeadlessTicker(2, 20*time.Millisecond),
+	}
+	RunWithBuiltins(prog, DefaultBuiltins(rt))
+	got := b.String()
+	assert.Equal(t, 2, strings.Count(got, "frame\n"), got)
+	assert.Equal(t, 1, strings.Count(got, "key a\n"), got)
+}
+
+func TestExit(t *testing.T) {
+	tests := map[string]string{
+		`
+print "start"
+exit
+print "unreachable"
+on frame
+	print "frame"
+end`: "start\n",
+		`
+on frame
+	print "frame" elapsed
+	if elapsed >= 0.05
+		exit
+	end
+end`: "frame 0\nframe 0.025\nframe 0.05\n",
+	}
+	for prog, want := range tests {
+		b := bytes.Buffer{}
+		rt := Runtime{
+			Print:  func(s string) { b.WriteString(s) },
+			Ticker: NewHeadlessTicker(100, 25*time.Millisecond),
+		}
+		err := RunWithBuiltinsErr(prog, DefaultBuiltins(rt))
+		assert.NoError(t, err, prog)
+		assert.Equal(t, want, b.String(), prog)
+	}
+}
+
+func TestTicker(t *testing.T) {
+	ticker := NewTicker(time.Millisecond)
+	ch := ticker.Start()
+	assert.Equal(t, time.Duration(0), <-ch)
+	prev := time.Duration(0)
+	for i := 0; i < 3; i++ {
+		elapsed := <-ch
+		assert.Equal(t, true, elapsed > prev, "elapsed must increase")
+		prev = elapsed
+	}
+	ticker.Stop()
+	for range ch { // drain until closed
+	}
+}
+
+func TestIsExit(t *testing.T) {
+	assert.Equal(t, true, IsExit(newError(ErrExit, "exit")))
+	assert.Equal(t, false, IsExit(newError(ErrPanic, "exit")))
+	assert.Equal(t, false, IsExit(errors.New("exit")))
+	assert.Equal(t, false, IsExit(nil))
+}
+
+func TestRuntimeError(t *testing.T) {
+	prog := `
+func inner:num m:{}num
+	return m.c
+end
+
+func outer:num
+	return (inner {a:1 b:2})
+end
+
+print "start"
+x := outer
+print x`
+	b := bytes.Buffer{}
+	rt := Runtime{Print: func(s string) { b.WriteString(s) }}
+	err := RunWithBuiltinsErr(prog, DefaultBuiltins(rt))
+	assert.Equal(t, "start\n", b.String())
+	evyErr, ok := err.(*Error)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, ErrKey, evyErr.Kind)
+	assert.Equal(t, "no value for key c", evyErr.Message)
+	assert.Equal(t, "line 3 column 10: no value for key c", evyErr.Error())
+	assert.Equal(t, []string{"outer", "inner"}, evyErr.Stack)
+	assert.Equal(t, "inner\nouter", evyErr.StackString())
+}
+
+func TestRuntimeErrorKind(t *testing.T) {
+	tests := map[string]ErrorKind{
+		"m := {}\nprint m.a":                 ErrKey,
+		"for i := range 1 2 0\nprint i\nend": ErrRange,
+	}
+	for in, want := range tests {
+		rt := Runtime{Print: func(s string) {}}
+		err := RunWithBuiltinsErr(in, DefaultBuiltins(rt))
+		evyErr, ok := err.(*Error)
+		assert.Equal(t, true, ok, in)
+		assert.Equal(t, want, evyErr.Kind, in)
+		assert.Equal(t, 0, len(evyErr.Stack), in)
+	}
+}
+
+func TestPanic(t *testing.T) {
+	prog := `
+func f
+	panic "oops"
+end
+print "start"
+f
+print "unreachable"`
+	b := bytes.Buffer{}
+	rt := Runtime{Print: func(s string) { b.WriteString(s) }}
+	err := RunWithBuiltinsErr(prog, DefaultBuiltins(rt))
+	assert.Equal(t, "start\n", b.String())
+	evyErr, ok := err.(*Error)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, ErrPanic, evyErr.Kind)
+	assert.Equal(t, "line 3 column 2: oops", evyErr.Error())
+	assert.Equal(t, []string{"f"}, evyErr.Stack)
+}
+
+func TestRecoverableError(t *testing.T) {
+	prog := `
+x := str2num "abc"
+print x error errnum
+print error
+print (len error) errnum
+y := str2num "12"
+print y error errnum
+b := str2bool "yes"
+e := error
+print "hello"
+print b e error errnum
+b = str2bool "true"
+print b error errnum
+arr := [1]
+arr[1] = 2
+print arr error errnum`
+	b := bytes.Buffer{}
+	rt := Runtime{Print: func(s string) { b.WriteString(s) }}
+	err := RunWithBuiltinsErr(prog, DefaultBuiltins(rt))
+	assert.Equal(t, nil, err)
+	want := []string{
+		`0 str2num: cannot parse "abc" 1`,
+		`str2num: cannot parse "abc"`,
+		"27 1",
+		"12  0",
+		"hello",
+		`false str2bool: cannot parse "yes" str2bool: cannot parse "yes" 1`,
+		"true  0",
+		"[1] index 1 out of bounds, should be between -1 and 0 3",
+		"",
+	}
+	assert.Equal(t, strings.Join(want, "\n"), b.String())
+}
+
+func TestAppendPrepend(t *testing.T) {
+	prog := `
+x := [1 2]
+y := x
+append x 3
+prepend x 0
+print x y
+
+func add arr:[]num
+	append arr 10
+end
+add x
+print y
+
+n := 5
+z:[]num
+append z n
+n = 6
+print z
+
+a:[]any
+append a 1
+append a "b"
+prepend a [true]
+print a
+
+m := [{a:1}]
+append m {b:2}
+print m
+
+x2 := x[:2]
+append x2 99
+print x2 x`
+	b := bytes.Buffer{}
+	fn := func(s string) { b.WriteString(s) }
+	Run(prog, fn)
+	want := []string{
+		"[0 1 2 3] [0 1 2 3]",
+		"[0 1 2 3 10]",
+		"[5]",
+		"[[true] 1 b]",
+		"[{a:1} {b:2}]",
+		"[0 1 99] [0 1 2 3 10]",
+		"",
+	}
+	assert.Equal(t, strings.Join(want, "\n"), b.String())
+}
+
+func TestMath(t *testing.T) {
+	tests := map[string]string{
+		"div 7 3":          "2",
+		"div -7 2":         "-3",
+		"div 7.9 2":        "3",
+		"div 1 0":          "+Inf",
+		"pow 2 3":          "8",
+		"pow 4 0.5":        "2",
+		"sqrt 9":           "3",
+		"logn 1":           "0",
+		"sin 0":            "0",
+		"sin pi/2":         "1",
+		"asin 1":           "1.5707963267948966",
+		"cos pi":           "-1",
+		"acos 1":           "0",
+		"tan 0":            "0",
+		"atan 1":           "0.7853981633974483",
+		"atan2 1 0":        "1.5707963267948966",
+		"atan2 0 -1":       "3.141592653589793",
+		"abs -21.34":       "21.34",
+		"floor 2.85":       "2",
+		"floor -2.15":      "-3",
+		"(floor pi*100)":   "314",
+		"(sqrt (pow 3 2))": "3",
+	}
+	for in, want := range tests {
+		in, want := in, want
+		t.Run(in, func(t *testing.T) {
+			in = "print (" + in + ")"
+			b := bytes.Buffer{}
+			fn := func(s string) { b.WriteString(s) }
+			Run(in, fn)
+			assert.Equal(t, want+"\n", b.String())
+		})
+	}
+}
+
+func TestRandom(t *testing.T) {
+	prog := `
+for i := range 20
+	n := random 10
+	f := randomf
+	if n < 0 or n >= 10 or n != (floor n) or f < 0 or f >= 1
+		print "out of range" n f
+	end
+	print i n f
+end`
+	run := func(seed int64) string {
+		b := bytes.Buffer{}
+		rt := Runtime{
+			Print: func(s string) { b.WriteString(s) },
+			Rand:  rand.New(rand.NewSource(seed)),
+		}
+		RunWithBuiltins(prog, DefaultBuiltins(rt))
+		return b.String()
+	}
+	got := run(1)
+	assert.Equal(t, 20, strings.Count(got, "\n"), got)
+	assert.Equal(t, false, strings.Contains(got, "out of range"), got)
+	assert.Equal(t, got, run(1))
+	assert.Equal(t, false, got == run(2))
+}
+
+func TestRandomErr(t *testing.T) {
+	tests := map[string]string{
+		"print (random 0)":          "line 1 column 8: random: n must be a positive number, found 0",
+		"print (random -1)":         "line 1 column 8: random: n must be a positive number, found -1",
+		"print (random 2.5)":        "line 1 column 8: random: n must be a whole number, found 2.5",
+		"print (random 3000000000)": "line 1 column 8: random: n must not be greater than 2147483647, found 3000000000",
+		"print (random 0/0)":        "line 1 column 8: random: n must be a whole number, found NaN",
+	}
+	for in, want := range tests {
+		rt := Runtime{Print: func(s string) {}}
+		err := RunWithBuiltinsErr(in, DefaultBuiltins(rt))
+		assert.Equal(t, want, err.Error(), in)
+	}
+	rt := Runtime{Print: func(s string) {}}
+	err := RunWithBuiltinsErr("print (random 2147483647)", DefaultBuiltins(rt))
+	assert.NoError(t, err)
+}
+
+type fakeClock struct {
+	t     time.Time
+	slept time.Duration
+}
+
+func (c *fakeClock) Now() time.Time { return c.t }
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.t = c.t.Add(d)
+	c.slept += d
+}
+
+// stopClock stops the evaluator instead of sleeping, like a new run
+// of an evy program in the browser while the current one sleeps.
+type stopClock struct {
+	realClock
+	e *Evaluator
+}
+
+func (c *stopClock) Sleep(time.Duration) {
+	c.e.Stop()
+}
+
+func TestStop(t *testing.T) {
+	prog := `
+print "start"
+on key_press
+	print "key"
+	sleep 1
+	print "unreachable"
+end`
+	b := bytes.Buffer{}
+	clock := &stopClock{}
+	rt := Runtime{
+		Print: func(s string) { b.WriteString(s) },
+		Clock: clock,
+	}
+	e := NewEvaluator(DefaultBuiltins(rt))
+	clock.e = e
+	assert.NoError(t, e.Run(prog))
+	err := e.HandleEvent(Event{Name: "key_press"})
+	assert.Equal(t, true, IsExit(err))
+	err = e.HandleEvent(Event{Name: "key_press"})
+	assert.Equal(t, true, IsExit(err))
+	assert.Equal(t, "start\nkey\n", b.String())
+}
+
+func TestTime(t *testing.T) {
+	prog := `
+t := now
+print t
+print (format_time t)
+sleep 1.5
+t2 := now
+print t2-t
+print (format_timef t2 "06/01/02 15:04:05.000")
+print (parse_time "2022-08-28T23:59:05Z")
+print (parse_time "2022-08-29T01:59:05+02:00")
+print (parse_timef "22/08/28 23:59" "06/01/02 15:04")
+print (format_time 0) (format_time -1.5)`
+	b := bytes.Buffer{}
+	clock := &fakeClock{t: time.Date(2022, 8, 28, 23, 59, 5, 0, time.FixedZone("X", 3600))}
+	rt := Runtime{
+		Print: func(s string) { b.WriteString(s) },
+		Clock: clock,
+	}
+	err := RunWithBuiltinsErr(prog, DefaultBuiltins(rt))
+	assert.Equal(t, nil, err)
+	want := []string{
+		"1661727545",
+		"2022-08-28T22:59:05Z",
+		"1.5",
+		"22/08/28 22:59:06.500",
+		"1661731145",
+		"1661731145",
+		"1661731140",
+		"1970-01-01T00:00:00Z 1969-12-31T23:59:58Z",
+		"",
+	}
+	assert.Equal(t, strings.Join(want, "\n"), b.String())
+	assert.Equal(t, 1500*time.Millisecond, clock.slept)
+}
+
+func TestParseTimeErr(t *testing.T) {
+	prog := `
+n := parse_time "2022-08-28"
+print n errnum error`
+	b := bytes.Buffer{}
+	fn := func(s string) { b.WriteString(s) }
+	Run(prog, fn)
+	want := `0 1 parse_time: parsing time "2022-08-28" as "2006-01-02T15:04:05Z07:00": cannot parse "" as "T"` + "\n"
+	assert.Equal(t, want, b.String())
+}
+
+func TestRead(t *testing.T) {
+	prog := `
+w1 := read
+w2 := read
+l1 := readln
+l2 := readln
+w3 := read
+w4 := read
+print w1 w2 "|" l1 "|" l2 "|" w3 w4
+w5 := read
+l3 := readln
+print w5 "|" l3
+eof := readln
+print "eof:" eof errnum error
+`
+	lines := []string{"  hello evy ", "a rest  line", "", "  full line", " ", "word", "last"}
+	read := func() (string, error) {
+		if len(lines) == 0 {
+			return "", io.EOF
+		}
+		line := lines[0]
+		lines = lines[1:]
+		return line, nil
+	}
+	b := bytes.Buffer{}
+	rt := Runtime{
+		Print: func(s string) { b.WriteString(s) },
+		Read:  read,
+	}
+	err := RunWithBuiltinsErr(prog, DefaultBuiltins(rt))
+	assert.Equal(t, nil, err)
+	want := []string{
+		"hello evy | a rest  line |  | full line",
+		"word | last",
+		"eof:  2 readln: EOF",
+		"",
+	}
+	assert.Equal(t, strings.Join(want, "\n"), b.String())
+}
+
+func TestReadID(t *testing.T) {
+	b := bytes.Buffer{}
+	rt := Runtime{
+		Print:  func(s string) { b.WriteString(s) },
+		ReadID: func(selector string) string { return "value of " + selector },
+	}
+	RunWithBuiltins(`print (readid "#name")`, DefaultBuiltins(rt))
+	assert.Equal(t, "value of #name\n", b.String())
+}
+
+func TestReadNotImplemented(t *testing.T) {
+	b := bytes.Buffer{}
+	fn := func(s string) { b.WriteString(s) }
+	Run(`s := read
+print "s:" s (len s)`, fn)
+	assert.Equal(t, "'read' not yet implemented\ns:  0\n", b.String())
+}
+
+func TestGraphics(t *testing.T) {
+	prog := `
+move 10 20
+stroke "red"
+fill "none"
+polygon [0 0] [10 0] [10 10]
+points := [[1 2] [3 4]]
+polyline points...
+curve 50 100 90 20
+textsize 5
+text "🦊 hello"
+save
+coordinates -1 -1 1 1
+restore
+clear`
+	b := bytes.Buffer{}
+	rec := func(args ...string) { b.WriteString(strings.Join(args, " ") + "\n") }
+	num := func(n float64) string { return strconv.FormatFloat(n, 'f', -1, 64) }
+	xys := func(vertices [][]float64) string {
+		s := make([]string, len(vertices))
+		for i, v := range vertices {
+			s[i] = num(v[0]) + "," + num(v[1])
+		}
+		return strings.Join(s, " ")
+	}
+	rt := Runtime{
+		Print: func(s string) { b.WriteString(s) },
+		Graphics: GraphicsRuntime{
+			Move:     func(x, y float64) { rec("move", num(x), num(y)) },
+			Stroke:   func(s string) { rec("stroke", s) },
+			Fill:     func(s string) { rec("fill", s) },
+			Polygon:  func(v [][]float64) { rec("polygon", xys(v)) },
+			Polyline: func(v [][]float64) { rec("polyline", xys(v)) },
+			Curve:    func(cx, cy, x, y float64) { rec("curve", num(cx), num(cy), num(x), num(y)) },
+			TextSize: func(size float64) { rec("textsize", num(size)) },
+			Text:     func(s string) { rec("text", s) },
+			Clear:    func() { rec("clear") },
+			Save:     func() { rec("save") },
+			Restore:  func() { rec("restore") },
+			Coordinates: func(minX, minY, maxX, maxY float64) {
+				rec("coordinates", num(minX), num(minY), num(maxX), num(maxY))
+			},
+		},
+	}
+	err := RunWithBuiltinsErr(prog, DefaultBuiltins(rt))
+	assert.NoError(t, err)
+	want := []string{
+		"move 10 20",
+		"stroke #ff0000",
+		"fill none",
+		"polygon 0,0 10,0 10,10",
+		"polyline 1,2 3,4",
+		"curve 50 100 90 20",
+		"textsize 5",
+		"text 🦊 hello",
+		"save",
+		"coordinates -1 -1 1 1",
+		"restore",
+		"clear",
+		"",
+	}
+	assert.Equal(t, strings.Join(want, "\n"), b.String())
+}
+
+func TestGraphicsErr(t *testing.T) {
+	rt := Runtime{
+		Print:    func(s string) {},
+		Graphics: GraphicsRuntime{Polygon: func([][]float64) {}},
+	}
+	err := RunWithBuiltinsErr("polygon [0 0] [1 2 3]", DefaultBuiltins(rt))
+	assert.Equal(t, "line 1 column 1: polygon: points must have 2 coordinates [x y], found [1 2 3]", err.Error())
+}
+
+func TestCoordinatesErr(t *testing.T) {
+	rt := Runtime{
+		Print:    func(s string) {},
+		Graphics: GraphicsRuntime{Coordinates: func(minX, minY, maxX, maxY float64) {}},
+	}
+	err := RunWithBuiltinsErr("coordinates 0 10 100 10", DefaultBuiltins(rt))
+	assert.Equal(t, "line 1 column 1: coordinates: min and max must differ, found 0 10 100 10", err.Error())
+}
+
+func TestColor(t *testing.T) {
+	tests := map[string]string{
+		`"red"`:                       "#ff0000",
+		`"RebeccaPurple"`:             "#663399",
+		`"transparent"`:               "#00000000",
+		`"none"`:                      "none",
+		`"#F00"`:                      "#ff0000",
+		`"#f008"`:                     "#ff000088",
+		`"#1e90ff"`:                   "#1e90ff",
+		`"#1e90ff80"`:                 "#1e90ff80",
+		`"#1e90ffff"`:                 "#1e90ff",
+		`"rgb(255 0 0)"`:              "#ff0000",
+		`"rgb(30, 144, 255)"`:         "#1e90ff",
+		`"rgba(255, 0, 0, 0.5)"`:      "#ff000080",
+		`"rgb(100% 50% 0% / 25%)"`:    "#ff800040",
+		`"rgb(300 -10 0)"`:            "#ff0000",
+		`"hsl(0 100% 50%)"`:           "#ff0000",
+		`"hsl(120deg, 100%, 25%)"`:    "#008000",
+		`"hsla(240, 100%, 50%, 0.5)"`: "#0000ff80",
+		`"hsl(-120 100% 50%)"`:        "#0000ff",
+		`"hsl(270 50% 40%)"`:          "#663399",
+		`" Red "`:                     "#ff0000",
+		"900":                         "#ff0000",
+		"90":                          "#00ff00",
+		"9":                           "#0000ff",
+		"0":                           "#000000",
+		"999":                         "#ffffff",
+		"543":                         "#8e7155",
+	}
+	for in, want := range tests {
+		in, want := in, want
+		t.Run(in, func(t *testing.T) {
+			var got []string
+			rt := Runtime{
+				Print: func(s string) {},
+				Graphics: GraphicsRuntime{
+					Color:  func(s string) { got = append(got, s) },
+					Stroke: func(s string) { got = append(got, s) },
+					Fill:   func(s string) { got = append(got, s) },
+				},
+			}
+			prog := "color " + in + "\ncolour " + in + "\nstroke " + in + "\nfill " + in
+			err := RunWithBuiltinsErr(prog, DefaultBuiltins(rt))
+			assert.NoError(t, err)
+			assert.Equal(t, []string{want, want, want, want}, got)
+		})
+	}
+}
+
+func TestColorErr(t *testing.T) {
+	tests := map[string]string{
+		`color "rde"`:             `color: invalid color "rde"`,
+		`colour ""`:               `colour: invalid color ""`,
+		`fill "#12"`:              `fill: invalid color "#12"`,
+		`stroke "#gggggg"`:        `stroke: invalid color "#gggggg"`,
+		`color "rgb(1 2)"`:        `color: invalid color "rgb(1 2)"`,
+		`color "rgb(1 2 x)"`:      `color: invalid color "rgb(1 2 x)"`,
+		`color "rgb(1 2 3"`:       `color: invalid color "rgb(1 2 3"`,
+		`color "hsl(x 100% 50%)"`: `color: invalid color "hsl(x 100% 50%)"`,
+		`color "rgba(1 2 3 4 5)"`: `color: invalid color "rgba(1 2 3 4 5)"`,
+		"color 1000":              "color: invalid color 1000",
+		"color -1":                "color: invalid color -1",
+		"color 1.5":               "color: invalid color 1.5",
+	}
+	for in, want := range tests {
+		rt := Runtime{
+			Print:    func(s string) {},
+			Graphics: GraphicsRuntime{Color: func(string) {}, Stroke: func(string) {}, Fill: func(string) {}},
+		}
+		err := RunWithBuiltinsErr(in, DefaultBuiltins(rt))
+		assert.Equal(t, true, err != nil, in)
+		evyErr, ok := err.(*Error)
+		assert.Equal(t, true, ok, in)
+		assert.Equal(t, ErrConversion, evyErr.Kind, in)
+		assert.Equal(t, want, evyErr.Message, in)
+	}
+}
+
+func TestPiReadonly(t *testing.T) {
+	rt := Runtime{Print: func(s string) {}}
+	err := RunWithBuiltinsErr("pi = 3", DefaultBuiltins(rt))
+	assert.Equal(t, "line 1 column 1: cannot assign to read-only variable 'pi'", err.Error())
+}
+
+func TestConversion(t *testing.T) {
+	tests := map[string]string{
+		`n := str2num "123"`:       "123 0 ",
+		`n := str2num "-1.5"`:      "-1.5 0 ",
+		`n := str2num "1e3"`:       "1000 0 ",
+		`n := str2num "1x"`:        `0 1 str2num: cannot parse "1x"`,
+		`n := str2num " 1"`:        `0 1 str2num: cannot parse " 1"`,
+		`n := str2num "inf"`:       `0 1 str2num: cannot parse "inf"`,
+		`n := str2num "-Infinity"`: `0 1 str2num: cannot parse "-Infinity"`,
+		`n := str2num "NaN"`:       `0 1 str2num: cannot parse "NaN"`,
+		`n := str2num "1_0"`:       `0 1 str2num: cannot parse "1_0"`,
+		`n := str2num "0x1p4"`:     `0 1 str2num: cannot parse "0x1p4"`,
+		`n := str2num "0x10"`:      `0 1 str2num: cannot parse "0x10"`,
+		`n := str2num "1e400"`:     `0 1 str2num: cannot parse "1e400"`,
+		`n := str2bool "true"`:     "true 0 ",
+		`n := str2bool "false"`:    "false 0 ",
+		`n := str2bool "TRUE"`:     `false 1 str2bool: cannot parse "TRUE"`,
+		`n := num2str 42`:          "42 0 ",
+		`n := num2str -0.25`:       "-0.25 0 ",
+		`n := num2str 1/3`:         "0.3333333333333333 0 ",
+		`n := bool2str true`:       "true 0 ",
+		`n := bool2str 1>2`:        "false 0 ",
+		`n := str2num (num2str 7)`: "7 0 ",
+	}
+	for in, want := range tests {
+		in, want := in, want
+		t.Run(in, func(t *testing.T) {
+			in += "\n e := errnum\n print n e error"
+			b := bytes.Buffer{}
+			fn := func(s string) { b.WriteString(s) }
+			Run(in, fn)
+			assert.Equal(t, want+"\n", b.String())
+		})
+	}
+}
+
+func TestConversionErrorReset(t *testing.T) {
+	prog := `
+n := str2num "x"
+print errnum
+n = str2num "1"
+print errnum n`
+	b := bytes.Buffer{}
+	fn := func(s string) { b.WriteString(s) }
+	Run(prog, fn)
+	assert.Equal(t, "1\n0 1\n", b.String())
+}
+
+func TestErrorReadonly(t *testing.T) {
+	rt := Runtime{Print: func(s string) {}}
+	err := RunWithBuiltinsErr(`error = "x"`, DefaultBuiltins(rt))
+	assert.Equal(t, "line 1 column 1: cannot assign to read-only variable 'error'", err.Error())
+}
+
+func TestParseErrorReturned(t *testing.T) {
+	rt := Runtime{Print: func(s string) {}}
+	err := RunWithBuiltinsErr("print x", DefaultBuiltins(rt))
+	assert.Equal(t, "line 1 column 7: unknown variable name 'x'", err.Error())
+	_, ok := err.(*Error)
+	assert.Equal(t, false, ok)
+}
+
 func TestDemo(t *testing.T) {
 	prog := `
 move 10 10
@@ -0,0 +1,114 @@
+package evaluator
+
+import (
+	"sort"
+	"time"
+
+	"foxygo.at/evy/pkg/parser"
+)
+
+// Event is an external event, such as a key press, which triggers the
+// event handler of the same name, e.g. `on key_press`. Params holds the
+// values of the event parameters in the order declared by the parser,
+// e.g. mouse_x and mouse_y for `on mouse_down`. Missing parameters are
+// set to their zero value.
+type Event struct {
+	Name   string
+	Params []Value
+}
+
+// EventSource provides the events for the event loop, which runs after
+// the top-level code of an evy program has been evaluated.
+//
+// Start is called once with the sorted names of all event handlers
+// declared in the program. Events are read from the returned channel
+// and dispatched until the channel is closed. Stop is called when the
+// event loop terminates early, e.g. because of an error, so that the
+// event source can release its resources.
+type EventSource interface {
+	Start(names []string) <-chan Event
+	Stop()
+}
+
+// HandleEvent evaluates the event handler for the given event. Events
+// without a matching event handler are ignored.
+func (e *Evaluator) HandleEvent(ev Event) error {
+	handler, ok := e.eventHandlers[ev.Name]
+	if !ok {
+		return nil
+	}
+	scope := newInnerScope(e.global)
+	for i, param := range handler.Params {
+		if i < len(ev.Params) {
+			scope.set(param.Name, ev.Params[i])
+		} else {
+			scope.set(param.Name, zero(param.T))
+		}
+	}
+	e.stack = append(e.stack, "on "+ev.Name)
+	_, err := e.Eval(scope, handler.Body)
+	e.stack = e.stack[:len(e.stack)-1]
+	return err
+}
+
+// EventHandlerNames returns the sorted names of all event handlers
+// declared in the evaluated program.
+func (e *Evaluator) EventHandlerNames() []string {
+	names := make([]string, 0, len(e.eventHandlers))
+	for name := range e.eventHandlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (e *Evaluator) registerEventHandlers(handlers map[string]*parser.EventHandler) {
+	e.eventHandlers = make(map[string]*parser.EventHandler, len(handlers))
+	for name, handler := range handlers {
+		e.eventHandlers[name] = handler
+	}
+}
+
+// runEventLoop dispatches all events from the event source and all
+// frames from the ticker to their handlers until both are exhausted or
+// an error occurs, e.g. by calling the exit builtin.
+func (e *Evaluator) runEventLoop(events EventSource, ticker Ticker) error {
+	if len(e.eventHandlers) == 0 {
+		return nil
+	}
+	var eventCh <-chan Event
+	if events != nil {
+		eventCh = events.Start(e.EventHandlerNames())
+	}
+	var frameCh <-chan time.Duration
+	if _, ok := e.eventHandlers["frame"]; ok && ticker != nil {
+		frameCh = ticker.Start()
+	}
+	for eventCh != nil || frameCh != nil {
+		var ev Event
+		select {
+		case event, ok := <-eventCh:
+			if !ok {
+				eventCh = nil
+				continue
+			}
+			ev = event
+		case elapsed, ok := <-frameCh:
+			if !ok {
+				frameCh = nil
+				continue
+			}
+			ev = FrameEvent(elapsed)
+		}
+		if err := e.HandleEvent(ev); err != nil {
+			if events != nil {
+				events.Stop()
+			}
+			if frameCh != nil {
+				ticker.Stop()
+			}
+			return err
+		}
+	}
+	return nil
+}
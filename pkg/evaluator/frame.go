@@ -0,0 +1,91 @@
+package evaluator
+
+import "time"
+
+// Ticker drives the `on frame` event handler. Start is called once
+// after the top-level code of an evy program has been evaluated, if the
+// program declares `on frame`. The returned channel yields the time
+// elapsed since the first frame for every frame and is closed when
+// there are no more frames. Stop is called when the event loop
+// terminates early, e.g. because of an error or the exit builtin.
+type Ticker interface {
+	Start() <-chan time.Duration
+	Stop()
+}
+
+// FrameEvent returns the frame event for the given time elapsed since
+// the first frame. Its `elapsed` parameter is in seconds.
+func FrameEvent(elapsed time.Duration) Event {
+	return Event{Name: "frame", Params: []Value{&Num{Val: elapsed.Seconds()}}}
+}
+
+// NewTicker returns a Ticker yielding a frame every interval in real
+// time, until stopped. Frames are dropped if their handling takes
+// longer than interval.
+func NewTicker(interval time.Duration) Ticker {
+	return &realTicker{interval: interval, done: make(chan struct{})}
+}
+
+type realTicker struct {
+	interval time.Duration
+	done     chan struct{}
+}
+
+func (t *realTicker) Start() <-chan time.Duration {
+	ch := make(chan time.Duration)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(t.interval)
+		defer ticker.Stop()
+		start := time.Now()
+		for now := start; ; {
+			select {
+			case ch <- now.Sub(start):
+			case <-t.done:
+				return
+			}
+			select {
+			case now = <-ticker.C:
+			case <-t.done:
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+func (t *realTicker) Stop() {
+	close(t.done)
+}
+
+// NewHeadlessTicker returns a Ticker yielding exactly the given number
+// of frames, interval apart in elapsed time, without waiting between
+// frames. It is used for tests and for drawing animations to files.
+func NewHeadlessTicker(frames int, interval time.Duration) Ticker {
+	return &headlessTicker{frames: frames, interval: interval, done: make(chan struct{})}
+}
+
+type headlessTicker struct {
+	frames   int
+	interval time.Duration
+	done     chan struct{}
+}
+
+func (t *headlessTicker) Start() <-chan time.Duration {
+	ch := make(chan time.Duration)
+	go func() {
+		defer close(ch)
+		for i := 0; i < t.frames; i++ {
+			select {
+			case ch <- time.Duration(i) * t.interval:
+			case <-t.done:
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+func (t *headlessTicker) Stop() {
+	close(t.done)
+}
@@ -58,7 +58,7 @@ func (m *mapRange) next() bool {
 		key := m.order[m.cur]
 		m.cur++
 		if _, ok := m.mapVal.Pairs[key]; ok { // ensure value hasn't been deleted
-			m.loopVar.(*String).Val = key
+			m.loopVar.(*String).setVal(key)
 			return true
 		}
 	}
@@ -72,7 +72,7 @@ func (s *stringRange) next() bool {
 	if s.cur >= len(s.runes) {
 		return false
 	}
-	s.loopVar.Val = string(s.runes[s.cur])
+	s.loopVar.setVal(string(s.runes[s.cur]))
 	s.cur++
 	return true
 }
@@ -1,17 +1,19 @@
 package evaluator
 
 import (
+	"math"
 	"strconv"
 	"strings"
+	"unicode"
 
+	"foxygo.at/evy/pkg/lexer"
 	"foxygo.at/evy/pkg/parser"
 )
 
 type ValueType int
 
 const (
-	ERROR ValueType = iota
-	NUM
+	NUM ValueType = iota
 	BOOL
 	STRING
 	ANY
@@ -24,7 +26,6 @@ const (
 )
 
 var valueTypeStrings = map[ValueType]string{
-	ERROR:        "error",
 	NUM:          "num",
 	BOOL:         "bool",
 	STRING:       "string",
@@ -52,6 +53,9 @@ type Value interface {
 	Equals(Value) bool // TODO: panic if wrong type
 	String() string    // TODO: panic if wrong type
 	Set(Value)
+	// Literal returns the value formatted as evy literal, which can be
+	// used in evy source code, e.g. "abc" quoted or [1 "a"].
+	Literal() string
 }
 
 type Num struct {
@@ -86,14 +90,25 @@ type ReturnValue struct {
 
 type Break struct{}
 
-type Error struct {
-	Message string
-}
-
 func (n *Num) Type() ValueType { return NUM }
 func (n *Num) String() string  { return strconv.FormatFloat(n.Val, 'f', -1, 64) }
+
+// Literal formats n as evy source code. NaN and infinities have no
+// literal, so they are formatted as the parenthesised division that
+// evaluates to them, e.g. (1/0) for +Inf.
+func (n *Num) Literal() string {
+	switch {
+	case math.IsNaN(n.Val):
+		return "(0/0)"
+	case math.IsInf(n.Val, 1):
+		return "(1/0)"
+	case math.IsInf(n.Val, -1):
+		return "(-1/0)"
+	}
+	return n.String()
+}
 func (n *Num) Equals(v Value) bool {
-	if n2, ok := v.(*Num); ok {
+	if n2, ok := unwrapAny(v).(*Num); ok {
 		return n.Val == n2.Val
 	}
 	return false // TODO: panic here when reworking ErrValue to panics; same in all Equals methods
@@ -108,8 +123,9 @@ func (n *Num) Set(v Value) {
 
 func (s *String) Type() ValueType { return STRING }
 func (s *String) String() string  { return s.Val }
+func (s *String) Literal() string { return quote(s.Val) }
 func (s *String) Equals(v Value) bool {
-	if s2, ok := v.(*String); ok {
+	if s2, ok := unwrapAny(v).(*String); ok {
 		return s.Val == s2.Val
 	}
 	return false
@@ -121,6 +137,13 @@ func (s *String) Set(v Value) {
 	}
 }
 
+// setVal updates the value of s in place and invalidates the cached
+// runes, e.g. for range loop variables.
+func (s *String) setVal(val string) {
+	s.Val = val
+	s.runeSlice = nil
+}
+
 func (s *String) runes() []rune {
 	if s.runeSlice == nil {
 		s.runeSlice = []rune(s.Val)
@@ -128,23 +151,23 @@ func (s *String) runes() []rune {
 	return s.runeSlice
 }
 
-func (s *String) Index(idx Value) Value {
+func (s *String) Index(idx Value) (Value, error) {
 	runes := s.runes()
 	i, err := normalizeIndex(idx, len(runes))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return &String{Val: string(runes[i])}
+	return &String{Val: string(runes[i])}, nil
 }
 
-func (s *String) Slice(start, end Value) Value {
+func (s *String) Slice(start, end Value) (Value, error) {
 	runes := s.runes()
 	length := len(runes)
 	startIdx, endIdx, err := normalizeSliceIndices(start, end, length)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return &String{Val: string(runes[startIdx:endIdx])}
+	return &String{Val: string(runes[startIdx:endIdx])}, nil
 }
 
 func (*Bool) Type() ValueType { return BOOL }
@@ -152,8 +175,10 @@ func (b *Bool) String() string {
 	return strconv.FormatBool(b.Val)
 }
 
+func (b *Bool) Literal() string { return b.String() }
+
 func (b *Bool) Equals(v Value) bool {
-	if b2, ok := v.(*Bool); ok {
+	if b2, ok := unwrapAny(v).(*Bool); ok {
 		return b.Val == b2.Val
 	}
 	return false
@@ -170,11 +195,10 @@ func (a *Any) String() string {
 	return a.Val.String()
 }
 
+func (a *Any) Literal() string { return a.Val.Literal() }
+
 func (a *Any) Equals(v Value) bool {
-	if a2, ok := v.(*Any); ok {
-		return a.Val.Equals(a2.Val)
-	}
-	return false // panic
+	return a.Val.Equals(unwrapAny(v))
 }
 
 func (a *Any) Set(v Value) {
@@ -189,16 +213,13 @@ func (r *ReturnValue) Type() ValueType     { return RETURN_VALUE }
 func (r *ReturnValue) String() string      { return r.Val.String() }
 func (r *ReturnValue) Equals(v Value) bool { return r.Val.Equals(v) }
 func (r *ReturnValue) Set(v Value)         { r.Val.Set(v) }
+func (r *ReturnValue) Literal() string     { return r.Val.Literal() }
 
 func (r *Break) Type() ValueType     { return BREAK }
 func (r *Break) String() string      { return "" }
 func (r *Break) Equals(_ Value) bool { return false }
 func (r *Break) Set(_ Value)         {}
-
-func (e *Error) Type() ValueType     { return ERROR }
-func (e *Error) String() string      { return "ERROR: " + e.Message }
-func (e *Error) Equals(_ Value) bool { return false }
-func (e *Error) Set(_ Value)         {}
+func (r *Break) Literal() string     { return "" }
 
 func (a *Array) Type() ValueType { return ARRAY }
 func (a *Array) String() string {
@@ -209,8 +230,16 @@ func (a *Array) String() string {
 	return "[" + strings.Join(elements, " ") + "]"
 }
 
+func (a *Array) Literal() string {
+	elements := make([]string, len(*a.Elements))
+	for i, e := range *a.Elements {
+		elements[i] = e.Literal()
+	}
+	return "[" + strings.Join(elements, " ") + "]"
+}
+
 func (a *Array) Equals(v Value) bool {
-	if a2, ok := v.(*Array); ok {
+	if a2, ok := unwrapAny(v).(*Array); ok {
 		if len(*a.Elements) != len(*a2.Elements) {
 			return false
 		}
@@ -232,13 +261,13 @@ func (a *Array) Set(v Value) {
 	}
 }
 
-func (a *Array) Index(idx Value) Value {
+func (a *Array) Index(idx Value) (Value, error) {
 	i, err := normalizeIndex(idx, len(*a.Elements))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	elements := *a.Elements
-	return elements[i]
+	return elements[i], nil
 }
 
 func (a *Array) Copy() *Array {
@@ -249,11 +278,11 @@ func (a *Array) Copy() *Array {
 	return &Array{Elements: &elements}
 }
 
-func (a *Array) Slice(start, end Value) Value {
+func (a *Array) Slice(start, end Value) (Value, error) {
 	length := len(*a.Elements)
 	startIdx, endIdx, err := normalizeSliceIndices(start, end, length)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	elements := make([]Value, endIdx-startIdx)
@@ -261,7 +290,7 @@ func (a *Array) Slice(start, end Value) Value {
 		v := (*a.Elements)[i]
 		elements[i-startIdx] = copyOrRef(v)
 	}
-	return &Array{Elements: &elements}
+	return &Array{Elements: &elements}, nil
 }
 
 // copyOrRef is a copy of the input value for basic types and a
@@ -293,8 +322,22 @@ func (m *Map) String() string {
 	return "{" + strings.Join(pairs, " ") + "}"
 }
 
+// Literal formats m as evy map literal. Keys that are not valid
+// identifiers, e.g. set with m["a b"], are quoted.
+func (m *Map) Literal() string {
+	pairs := make([]string, 0, len(m.Pairs))
+	for _, key := range *m.Order {
+		k := key
+		if !isIdentifier(key) {
+			k = quote(key)
+		}
+		pairs = append(pairs, k+":"+m.Pairs[key].Literal())
+	}
+	return "{" + strings.Join(pairs, " ") + "}"
+}
+
 func (m *Map) Equals(v Value) bool {
-	if m2, ok := v.(*Map); ok {
+	if m2, ok := unwrapAny(v).(*Map); ok {
 		if len(m.Pairs) != len(m2.Pairs) {
 			return false
 		}
@@ -315,12 +358,12 @@ func (m *Map) Set(v Value) {
 	}
 }
 
-func (m *Map) Get(key string) Value {
+func (m *Map) Get(key string) (Value, error) {
 	val, ok := m.Pairs[key]
 	if !ok {
-		return newError("no value for key " + key)
+		return nil, newError(ErrKey, "no value for key "+key)
 	}
-	return val
+	return val, nil
 }
 
 func (m *Map) InsertKey(key string, t *parser.Type) {
@@ -344,10 +387,6 @@ func (m *Map) Delete(key string) {
 	}
 }
 
-func isError(val Value) bool { // TODO: replace with panic flow
-	return val != nil && val.Type() == ERROR
-}
-
 func isReturn(val Value) bool {
 	return val != nil && val.Type() == RETURN_VALUE
 }
@@ -356,13 +395,116 @@ func isBreak(val Value) bool {
 	return val != nil && val.Type() == BREAK
 }
 
-func newError(msg string) *Error {
-	return &Error{Message: msg}
+func unwrapAny(val Value) Value {
+	if a, ok := val.(*Any); ok {
+		return a.Val
+	}
+	return val
+}
+
+// hasType reports whether val can be used as a value of type t. As
+// values do not carry their static type, composite values are checked
+// structurally: all elements of an array or map must have the sub type
+// of t. Empty arrays and maps match any array or map type.
+func hasType(val Value, t *parser.Type) bool {
+	val = unwrapAny(val)
+	switch t.Name {
+	case parser.ANY:
+		return true
+	case parser.NUM:
+		return val.Type() == NUM
+	case parser.STRING:
+		return val.Type() == STRING
+	case parser.BOOL:
+		return val.Type() == BOOL
+	case parser.ARRAY:
+		arr, ok := val.(*Array)
+		if !ok {
+			return false
+		}
+		for _, elem := range *arr.Elements {
+			if !hasType(elem, t.Sub) {
+				return false
+			}
+		}
+		return true
+	case parser.MAP:
+		m, ok := val.(*Map)
+		if !ok {
+			return false
+		}
+		for _, elem := range m.Pairs {
+			if !hasType(elem, t.Sub) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// typeString returns a description of the dynamic type of val, such as
+// "num" or "array". Composite values are not described any further.
+func typeString(val Value) string {
+	return unwrapAny(val).Type().String()
+}
+
+// quote returns s as double quoted evy string literal, escaping `"`,
+// `\`, newlines, tabs and other non-printable characters.
+func quote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		case r == '\n':
+			sb.WriteString(`\n`)
+		case r == '\t':
+			sb.WriteString(`\t`)
+		case !unicode.IsPrint(r):
+			sb.WriteString(`\u{` + strconv.FormatInt(int64(r), 16) + "}")
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// isIdentifier reports whether s can be used as map key in an evy map
+// literal.
+func isIdentifier(s string) bool {
+	if s == "" || lexer.LookupKeyword(s) != lexer.IDENT {
+		return false
+	}
+	for i, r := range s {
+		isDigit := r >= '0' && r <= '9'
+		if !unicode.IsLetter(r) && r != '_' && (i == 0 || !isDigit) {
+			return false
+		}
+	}
+	return true
+}
+
+// valueType returns the static type of a builtin global variable
+// with value val.
+func valueType(val Value) *parser.Type {
+	switch val.Type() {
+	case NUM:
+		return parser.NUM_TYPE
+	case STRING:
+		return parser.STRING_TYPE
+	case BOOL:
+		return parser.BOOL_TYPE
+	}
+	return parser.ANY_TYPE
 }
 
-func normalizeSliceIndices(start, end Value, length int) (int, int, Value) {
+func normalizeSliceIndices(start, end Value, length int) (int, int, error) {
 	startIdx := 0
-	var err Value
+	var err error
 	if start != nil {
 		startIdx, err = normalizeIndex(start, length)
 		if err != nil {
@@ -381,21 +523,21 @@ func normalizeSliceIndices(start, end Value, length int) (int, int, Value) {
 	}
 	if startIdx > endIdx {
 		msg := "invalid slice indices: " + strconv.Itoa(startIdx) + " > " + strconv.Itoa(endIdx)
-		return 0, 0, newError(msg)
+		return 0, 0, newRecoverableError(ErrBounds, msg)
 	}
 	return startIdx, endIdx, nil
 }
 
-func normalizeIndex(idx Value, length int) (int, Value) {
+func normalizeIndex(idx Value, length int) (int, error) {
 	index, ok := idx.(*Num)
 	if !ok {
-		return 0, newError("expected index of type num, found " + idx.Type().String())
+		return 0, newError(ErrInternal, "expected index of type num, found "+idx.Type().String())
 	}
 	i := int(index.Val)
 	if i < -length || i >= length {
 		boundsStr := strconv.Itoa(-length) + " and " + strconv.Itoa(length-1)
 		msg := "index " + strconv.Itoa(i) + " out of bounds, should be between " + boundsStr
-		return 0, newError(msg)
+		return 0, newRecoverableError(ErrBounds, msg)
 	}
 	if i < 0 {
 		return length + i, nil // -1 references len-1 i.e. last element
@@ -420,5 +562,5 @@ func zero(t *parser.Type) Value {
 		order := []string{}
 		return &Map{Pairs: map[string]Value{}, Order: &order}
 	}
-	return newError("cannot create zero value for type " + t.String())
+	return nil // unreachable for types created by the parser
 }
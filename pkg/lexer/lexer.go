@@ -5,8 +5,10 @@
 package lexer
 
 import (
+	"strconv"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 func Run(input string) string {
@@ -67,6 +69,8 @@ func (l *Lexer) Next() *Token {
 		return tok.SetType(SLASH)
 	case '*':
 		return tok.SetType(ASTERISK)
+	case '%':
+		return tok.SetType(PERCENT)
 	case '<':
 		if l.peekRune() == '=' {
 			l.advance()
@@ -107,11 +111,15 @@ func (l *Lexer) Next() *Token {
 		}
 		return tok.SetType(DOT)
 	case '"':
-		literal := l.readString()
+		literal, terminated, illegal := l.readString()
+		if illegal != nil {
+			return illegal
+		}
 		// EOF or NL before closing `"`
-		if l.cur != '"' {
+		if !terminated {
 			return tok.SetType(ILLEGAL).SetLiteral(`"`)
 		}
+		tok.Raw = string(l.input[tok.Offset : l.pos+1])
 		return tok.SetType(STRING_LIT).SetLiteral(literal)
 	case 0:
 		return tok.SetType(EOF)
@@ -186,21 +194,76 @@ func (l *Lexer) readIdent() string {
 	return l.readWhile(func(r rune) bool { return isLetter(r) || unicode.IsDigit(r) })
 }
 
-func (l *Lexer) readString() string {
-	pos := l.pos + 1
+// readString reads a string literal starting at the opening `"` and
+// returns its unescaped value. terminated is false if the literal is
+// not closed by `"` before the end of line or input. illegal is an
+// ILLEGAL token for the first invalid escape sequence, if any. The
+// remainder of the string literal is read regardless.
+func (l *Lexer) readString() (val string, terminated bool, illegal *Token) {
+	var sb strings.Builder
 	for {
-		l.advance()
 		pr := l.peekRune()
-		if pr == '"' && l.cur != '\\' {
-			l.advance()
-			break
-		}
 		if pr == 0 || pr == '\n' {
-			break
+			return sb.String(), false, illegal
 		}
+		l.advance()
+		switch l.cur {
+		case '"':
+			return sb.String(), true, illegal
+		case '\\':
+			if pr := l.peekRune(); pr == 0 || pr == '\n' {
+				continue // unterminated string
+			}
+			tok := &Token{Offset: l.pos, Line: l.line, Col: l.col}
+			r, ok := l.readEscape()
+			if !ok && illegal == nil {
+				literal := string(l.input[tok.Offset : l.pos+1])
+				illegal = tok.SetType(ILLEGAL).SetLiteral(literal)
+			}
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune(l.cur)
+		}
+	}
+}
+
+// readEscape reads the escape sequence starting at the backslash in
+// l.cur: \" \\ \n \t or \u{...} with 1 to 6 hex digits. It returns the
+// escaped rune and false for invalid escape sequences.
+func (l *Lexer) readEscape() (rune, bool) {
+	l.advance() // advance past `\`
+	switch l.cur {
+	case '"', '\\':
+		return l.cur, true
+	case 'n':
+		return '\n', true
+	case 't':
+		return '\t', true
+	case 'u':
+		return l.readUnicodeEscape()
 	}
-	s := string(l.input[pos:l.pos])
-	return strings.ReplaceAll(s, `\"`, `"`)
+	return utf8.RuneError, false
+}
+
+func (l *Lexer) readUnicodeEscape() (rune, bool) {
+	if l.peekRune() != '{' {
+		return utf8.RuneError, false
+	}
+	l.advance()
+	hex := l.readWhile(isHexDigit)[1:]
+	if l.peekRune() != '}' {
+		return utf8.RuneError, false
+	}
+	l.advance()
+	if len(hex) == 0 || len(hex) > 6 {
+		return utf8.RuneError, false
+	}
+	n, err := strconv.ParseUint(hex, 16, 32)
+	r := rune(n)
+	if err != nil || !utf8.ValidRune(r) {
+		return utf8.RuneError, false
+	}
+	return r, true
 }
 
 func isLetter(r rune) bool {
@@ -210,3 +273,7 @@ func isLetter(r rune) bool {
 func isDigit(r rune) bool {
 	return r >= '0' && r <= '9'
 }
+
+func isHexDigit(r rune) bool {
+	return isDigit(r) || (r >= 'a' && r <= 'f') || (r >= 'A' && r <= 'F')
+}
@@ -18,6 +18,7 @@ func TestSingleToken(t *testing.T) {
 		{in: "!", want: BANG},
 		{in: "*", want: ASTERISK},
 		{in: "/", want: SLASH},
+		{in: "%", want: PERCENT},
 		{in: "==", want: EQ},
 		{in: "!=", want: NOT_EQ},
 		{in: "<", want: LT},
@@ -85,16 +86,25 @@ func TestSingleTokenWithLiteral(t *testing.T) {
 			want: &Token{Type: IDENT, Literal: "x2", Offset: 0, Line: 1, Col: 1},
 		}, "STRING": {
 			in:   `"xy" `,
-			want: &Token{Type: STRING_LIT, Literal: "xy", Offset: 0, Line: 1, Col: 1},
+			want: &Token{Type: STRING_LIT, Literal: "xy", Raw: `"xy"`, Offset: 0, Line: 1, Col: 1},
 		}, "STRING whitespace": {
 			in:   `" x y "  `,
-			want: &Token{Type: STRING_LIT, Literal: " x y ", Offset: 0, Line: 1, Col: 1},
+			want: &Token{Type: STRING_LIT, Literal: " x y ", Raw: `" x y "`, Offset: 0, Line: 1, Col: 1},
 		}, "STRING WITH COMMENT TOKEN": {
 			in:   `"x//y" `,
-			want: &Token{Type: STRING_LIT, Literal: "x//y", Offset: 0, Line: 1, Col: 1},
+			want: &Token{Type: STRING_LIT, Literal: "x//y", Raw: `"x//y"`, Offset: 0, Line: 1, Col: 1},
 		}, "STRING escaped quote": {
 			in:   `"xy\""    `,
-			want: &Token{Type: STRING_LIT, Literal: `xy"`, Offset: 0, Line: 1, Col: 1},
+			want: &Token{Type: STRING_LIT, Literal: `xy"`, Raw: `"xy\""`, Offset: 0, Line: 1, Col: 1},
+		}, "STRING empty": {
+			in:   `"" `,
+			want: &Token{Type: STRING_LIT, Literal: "", Raw: `""`, Offset: 0, Line: 1, Col: 1},
+		}, "STRING escapes": {
+			in:   `"a\\b\nc\td" `,
+			want: &Token{Type: STRING_LIT, Literal: "a\\b\nc\td", Raw: `"a\\b\nc\td"`, Offset: 0, Line: 1, Col: 1},
+		}, "STRING unicode escape": {
+			in:   `"\u{1F98A}\u{e9}" `,
+			want: &Token{Type: STRING_LIT, Literal: "🦊é", Raw: `"\u{1F98A}\u{e9}"`, Offset: 0, Line: 1, Col: 1},
 		}, "NUM": {
 			in:   "1  \t ",
 			want: &Token{Type: NUM_LIT, Literal: "1", Offset: 0, Line: 1, Col: 1},
@@ -185,7 +195,7 @@ s = s[:1] // "bc"`
 		{Type: WS, Literal: "", Offset: 11, Line: 3, Col: 2},
 		{Type: ASSIGN, Literal: "", Offset: 12, Line: 3, Col: 3},
 		{Type: WS, Literal: "", Offset: 13, Line: 3, Col: 4},
-		{Type: STRING_LIT, Literal: "abc", Offset: 14, Line: 3, Col: 5},
+		{Type: STRING_LIT, Literal: "abc", Raw: `"abc"`, Offset: 14, Line: 3, Col: 5},
 		{Type: NL, Literal: "", Offset: 19, Line: 3, Col: 10},
 
 		{Type: IDENT, Literal: "s", Offset: 20, Line: 4, Col: 1},
@@ -224,11 +234,11 @@ end
 		{Type: LCURLY, Literal: "", Offset: 6, Line: 2, Col: 6},
 		{Type: IDENT, Literal: "name", Offset: 7, Line: 2, Col: 7},
 		{Type: COLON, Literal: "", Offset: 11, Line: 2, Col: 11},
-		{Type: STRING_LIT, Literal: "Mali", Offset: 12, Line: 2, Col: 12},
+		{Type: STRING_LIT, Literal: "Mali", Raw: `"Mali"`, Offset: 12, Line: 2, Col: 12},
 		{Type: WS, Literal: "", Offset: 18, Line: 2, Col: 18},
 		{Type: IDENT, Literal: "sport", Offset: 19, Line: 2, Col: 19},
 		{Type: COLON, Literal: "", Offset: 24, Line: 2, Col: 24},
-		{Type: STRING_LIT, Literal: "climbing", Offset: 25, Line: 2, Col: 25},
+		{Type: STRING_LIT, Literal: "climbing", Raw: `"climbing"`, Offset: 25, Line: 2, Col: 25},
 		{Type: RCURLY, Literal: "", Offset: 35, Line: 2, Col: 35},
 		{Type: NL, Literal: "", Offset: 36, Line: 2, Col: 36},
 
@@ -277,6 +287,13 @@ func TestIllegal(t *testing.T) {
 		{in: `"unterminated`, want: Token{Offset: 0, Literal: `"`, Line: 1, Col: 1}},
 		{in: `"newline in the
 		middle "`, want: Token{Offset: 0, Literal: `"`, Line: 1, Col: 1}},
+		{in: `"ab\q"`, want: Token{Offset: 3, Literal: `\q`, Line: 1, Col: 4}},
+		{in: `"\u{zz}"`, want: Token{Offset: 1, Literal: `\u{`, Line: 1, Col: 2}},
+		{in: `"\u{110000}"`, want: Token{Offset: 1, Literal: `\u{110000}`, Line: 1, Col: 2}},
+		{in: `"\u{}"`, want: Token{Offset: 1, Literal: `\u{}`, Line: 1, Col: 2}},
+		{in: `"\u12"`, want: Token{Offset: 1, Literal: `\u`, Line: 1, Col: 2}},
+		{in: `"\q`, want: Token{Offset: 1, Literal: `\q`, Line: 1, Col: 2}},
+		{in: `"abc\`, want: Token{Offset: 0, Literal: `"`, Line: 1, Col: 1}},
 	}
 	for _, tt := range tests {
 		tt := tt
@@ -299,6 +316,7 @@ func TestRun(t *testing.T) {
 		{in: ` "abc"`, want: "WS\nSTRING_LIT 'abc'\n"},
 		{in: ",", want: "ILLEGAL 💥 ',' at line 1 column 1\n"},
 		{in: `"asdf `, want: "ILLEGAL 💥 '\"' at line 1 column 1\n"},
+		{in: `"\q"`, want: "ILLEGAL 💥 '\\q' at line 1 column 2\n"},
 	}
 
 	for _, tt := range tests {
@@ -6,6 +6,7 @@ import (
 
 type Token struct {
 	Literal string
+	Raw     string // source text of string literals, with quotes and escapes
 
 	Offset int
 	Line   int
@@ -33,6 +34,7 @@ const (
 	BANG     // !
 	ASTERISK // *
 	SLASH    // /
+	PERCENT  // %
 
 	EQ     // ==
 	NOT_EQ // !=
@@ -127,6 +129,7 @@ var tokenStrings = map[TokenType]tokenString{
 	BANG:       {string: "BANG", format: "!"},
 	ASTERISK:   {string: "ASTERISK", format: "*"},
 	SLASH:      {string: "SLASH", format: "/"},
+	PERCENT:    {string: "PERCENT", format: "%"},
 	LT:         {string: "LT", format: "<"},
 	GT:         {string: "GT", format: ">"},
 	LTEQ:       {string: "LTEQ", format: "<="},
@@ -221,7 +224,7 @@ func (t *Token) Format() string {
 	case COMMENT, IDENT, NUM_LIT:
 		return t.Literal
 	case STRING_LIT:
-		return `"` + t.Literal + `"`
+		return t.formatString()
 	}
 	return t.Type.Format()
 }
@@ -231,11 +234,18 @@ func (t *Token) FormatDetails() string {
 	case COMMENT, IDENT, NUM_LIT:
 		return t.Literal
 	case STRING_LIT:
-		return `"` + t.Literal + `"`
+		return t.formatString()
 	}
 	return t.Type.FormatDetails()
 }
 
+func (t *Token) formatString() string {
+	if t.Raw != "" {
+		return t.Raw
+	}
+	return `"` + t.Literal + `"`
+}
+
 func (t *Token) Location() string {
 	return "line " + strconv.Itoa(t.Line) + " column " + strconv.Itoa(t.Col)
 }
@@ -14,6 +14,7 @@ type Node interface {
 
 type Program struct {
 	Statements       []Node
+	EventHandlers    map[string]*EventHandler
 	alwaysTerminates bool
 }
 
@@ -21,6 +22,7 @@ type FunctionCall struct {
 	Token     *lexer.Token // The IDENT of the function
 	Name      string
 	Arguments []Node
+	Spread    bool // last argument is an array spread into variadic arguments: `f arr...`
 	FuncDecl  *FuncDecl
 }
 
@@ -60,6 +62,12 @@ type DotExpression struct {
 	Key   string // m := { age: 42}; m.age => key: "age"
 }
 
+type TypeAssertion struct {
+	T     *Type
+	Token *lexer.Token // The . token
+	Left  Node
+}
+
 type Declaration struct {
 	Token *lexer.Token
 	Var   *Var
@@ -126,15 +134,18 @@ type ConditionalBlock struct {
 }
 
 type EventHandler struct {
-	Name string
-	Body *BlockStatement
+	Token  *lexer.Token // The 'on' token
+	Name   string
+	Params []*Var // Implicitly declared event parameters, e.g. key
+	Body   *BlockStatement
 }
 
 type Var struct {
-	Token  *lexer.Token
-	Name   string
-	T      *Type
-	isUsed bool
+	Token    *lexer.Token
+	Name     string
+	T        *Type
+	isUsed   bool
+	readonly bool // event parameters cannot be assigned to
 }
 
 type BlockStatement struct {
@@ -189,6 +200,9 @@ func (f *FunctionCall) String() string {
 		s[i] = arg.String()
 	}
 	args := strings.Join(s, ", ")
+	if f.Spread {
+		args += "..."
+	}
 	return f.Name + "(" + args + ")"
 }
 
@@ -247,6 +261,14 @@ func (d *DotExpression) Type() *Type {
 	return d.T
 }
 
+func (t *TypeAssertion) String() string {
+	return "(" + t.Left.String() + ".(" + t.T.Format() + "))"
+}
+
+func (t *TypeAssertion) Type() *Type {
+	return t.T
+}
+
 func (d *Declaration) String() string {
 	if d.Value == nil {
 		return d.Var.String()
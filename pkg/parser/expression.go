@@ -40,6 +40,7 @@ var precedences = map[lexer.TokenType]precedence{
 	lexer.OR:       OR,
 	lexer.SLASH:    PRODUCT,
 	lexer.ASTERISK: PRODUCT,
+	lexer.PERCENT:  PRODUCT,
 	lexer.AND:      AND,
 	lexer.LBRACKET: INDEX,
 	lexer.DOT:      INDEX,
@@ -58,10 +59,50 @@ func (p *Parser) parseFuncCall(scope *scope) Node {
 	p.advance() // advance past function name IDENT
 	fc.FuncDecl = p.funcs[fc.Name]
 	fc.Arguments = p.parseExprList(scope)
+	if p.cur.TokenType() == lexer.DOT3 {
+		if !p.parseSpread(fc) {
+			return nil
+		}
+		return fc
+	}
 	p.assertArgTypes(fc.FuncDecl, fc.Arguments)
 	return fc
 }
 
+// parseSpread parses the `...` following the array argument of a
+// variadic function call, e.g. `addmany arr...`, and validates the
+// array type against the variadic parameter.
+func (p *Parser) parseSpread(fc *FunctionCall) bool {
+	tok := p.cur
+	wsBefore := p.lookAt(p.pos-1).Type == lexer.WS
+	p.advance() // advance past ...
+	if wsBefore {
+		p.appendErrorForToken("unexpected whitespace before '...'", tok)
+		return false
+	}
+	fc.Spread = true
+	decl := fc.FuncDecl
+	if decl.VariadicParam == nil {
+		p.appendErrorForToken("'...' can only be used with variadic function, '"+fc.Name+"' is not variadic", tok)
+		return false
+	}
+	if len(fc.Arguments) != 1 {
+		p.appendErrorForToken("'...' can only be used with a single argument, found "+strconv.Itoa(len(fc.Arguments)), tok)
+		return false
+	}
+	paramType := decl.VariadicParam.Type()
+	argType := fc.Arguments[0].Type()
+	if argType.Name != ARRAY {
+		p.appendErrorForToken("'...' expects array argument, found "+argType.Format(), tok)
+		return false
+	}
+	if argType != GENERIC_ARRAY && !paramType.Accepts(argType.Sub) && !paramType.Matches(argType.Sub) {
+		p.appendErrorForToken("'"+fc.Name+"' takes variadic arguments of type '"+paramType.Format()+"', found '"+argType.Format()+"...'", tok)
+		return false
+	}
+	return true
+}
+
 func (p *Parser) parseExpr(scope *scope, prec precedence) Node {
 	var left Node
 	switch p.cur.Type {
@@ -247,6 +288,9 @@ func (p *Parser) parseDotExpr(left Node) Node {
 		return nil
 	}
 	p.advance() // advance past .
+	if p.cur.TokenType() == lexer.LPAREN {
+		return p.parseTypeAssertion(left, tok)
+	}
 	leftType := left.Type().Name
 	if leftType != MAP {
 		p.appendErrorForToken("field access with '.' expects map type, found "+left.Type().Format(), tok)
@@ -261,8 +305,29 @@ func (p *Parser) parseDotExpr(left Node) Node {
 	return expr
 }
 
+// parseTypeAssertion parses `.(TYPE)` after the `.` of a type
+// assertion, e.g. `x.([]num)`.
+func (p *Parser) parseTypeAssertion(left Node, tok *lexer.Token) Node {
+	if left.Type() != ANY_TYPE {
+		p.appendErrorForToken("value of type "+left.Type().Format()+" cannot be type asserted", tok)
+		return nil
+	}
+	p.advance() // advance past (
+	typeTok := p.cur
+	t := p.parseType()
+	if t == ILLEGAL_TYPE {
+		p.appendErrorForToken("invalid type in type assertion of '"+left.String()+"'", typeTok)
+		return nil
+	}
+	if !p.assertToken(lexer.RPAREN) {
+		return nil
+	}
+	p.advance() // advance past )
+	return &TypeAssertion{Token: tok, Left: left, T: t}
+}
+
 func isBinaryOp(tt lexer.TokenType) bool {
-	return isComparisonOp(tt) || tt == lexer.PLUS || tt == lexer.MINUS || tt == lexer.SLASH || tt == lexer.ASTERISK || tt == lexer.OR || tt == lexer.AND
+	return isComparisonOp(tt) || tt == lexer.PLUS || tt == lexer.MINUS || tt == lexer.SLASH || tt == lexer.ASTERISK || tt == lexer.PERCENT || tt == lexer.OR || tt == lexer.AND
 }
 
 func isComparisonOp(tt lexer.TokenType) bool {
@@ -296,6 +361,12 @@ func (p *Parser) validateBinaryType(binaryExp *BinaryExpression) {
 
 	leftType := binaryExp.Left.Type()
 	rightType := binaryExp.Right.Type()
+	if op == OP_EQ || op == OP_NOT_EQ {
+		if !leftType.comparable(rightType) {
+			p.appendErrorForToken("mismatched type for "+op.String()+": "+leftType.Format()+", "+rightType.Format(), tok)
+		}
+		return
+	}
 	if !leftType.Matches(rightType) {
 		p.appendErrorForToken("mismatched type for "+op.String()+": "+leftType.Format()+", "+rightType.Format(), tok)
 		return
@@ -306,7 +377,7 @@ func (p *Parser) validateBinaryType(binaryExp *BinaryExpression) {
 		if leftType != NUM_TYPE && leftType != STRING_TYPE && leftType.Name != ARRAY {
 			p.appendErrorForToken("'+' takes num, string or array type, found "+leftType.Format(), tok)
 		}
-	case OP_MINUS, OP_SLASH, OP_ASTERISK:
+	case OP_MINUS, OP_SLASH, OP_ASTERISK, OP_PERCENT:
 		if leftType != NUM_TYPE {
 			p.appendErrorForToken("'"+op.String()+"' takes num type, found "+leftType.Format(), tok)
 		}
@@ -374,7 +445,7 @@ func (p *Parser) parseArrayLiteral(scope *scope) Node {
 func (p *Parser) parseExprList(scope *scope) []Node {
 	list := []Node{}
 	tt := p.cur.TokenType()
-	for !p.isAtEOL() && tt != lexer.RPAREN && tt != lexer.RBRACKET {
+	for !p.isAtEOL() && tt != lexer.RPAREN && tt != lexer.RBRACKET && tt != lexer.DOT3 {
 		n := p.parseExprWSS(scope, LOWEST)
 		if n == nil {
 			return nil // previous error
@@ -437,11 +508,11 @@ func (p *Parser) parseMapPairs(scope *scope) (map[string]Node, []string) {
 	tt := p.cur.TokenType()
 
 	for !p.isAtEOL() && tt != lexer.RCURLY {
-		if tt != lexer.IDENT {
+		if tt != lexer.IDENT && tt != lexer.STRING_LIT {
 			p.appendError("expected map key, found " + p.cur.FormatDetails())
 		}
 		key := p.cur.Literal
-		p.advance() // advance past key IDENT
+		p.advance() // advance past key IDENT or STRING_LIT
 		if _, ok := pairs[key]; ok {
 			p.appendError("duplicated map key'" + key + "'")
 			return nil, nil
@@ -43,6 +43,8 @@ func TestParseTopLevelExpression(t *testing.T) {
 		"(1+2)+3":                "((1+2)+3)",
 		"(1*2)+3":                "((1*2)+3)",
 		"(1+2)*3":                "((1+2)*3)",
+		"1+2%3":                  "(1+(2%3))",
+		"(1+2)%3*4":              "(((1+2)%3)*4)",
 		"n1<3 + 7 * (n2-1)":      "(n1<(3+(7*(n2-1))))",
 		"n1<3 + 7 * (n2-1)or !b": "((n1<(3+(7*(n2-1)))) or (!b))",
 
@@ -146,6 +148,19 @@ func TestParseTopLevelExpression(t *testing.T) {
 		"arr[1:]":  "(arr[1:])",
 		"arr[:2]":  "(arr[:2])",
 		"arr[:]":   "(arr[:])",
+
+		// type assertions
+		"x.(num)":      "(x.(num))",
+		"x.([]num)":    "(x.(num[]))",
+		"x.({}[]any)":  "(x.(any[]{}))",
+		"x.(num) + 1":  "((x.(num))+1)",
+		"x.({}num).a":  "((x.(num{})).a)",
+		"x.([]num)[1]": "((x.(num[]))[1])",
+
+		// comparisons with any
+		"x == 1":       "(x==1)",
+		"map != x":     "(map!=x)",
+		`{a:x} == map`: "({a:x}==map)",
 	}
 	for input, want := range tests {
 		parser := New(input, testBuiltins())
@@ -167,6 +182,7 @@ func TestParseTopLevelExpression(t *testing.T) {
 		scope.set("list", &Var{Name: "list", T: arrayMapType})
 		mapArrayType := &Type{Name: MAP, Sub: arrType}
 		scope.set("map3", &Var{Name: "map3", T: mapArrayType})
+		scope.set("x", &Var{Name: "x", T: ANY_TYPE})
 
 		got := parser.parseTopLevelExpr(scope)
 		assertNoParseError(t, parser, input)
@@ -187,6 +203,7 @@ func TestParseTopLevelExpressionErr(t *testing.T) {
 
 		"true + false": "line 1 column 6: '+' takes num, string or array type, found bool",
 		"true - false": "line 1 column 6: '-' takes num type, found bool",
+		`"a" % "b"`:    "line 1 column 5: '%' takes num type, found string",
 		"true < false": "line 1 column 6: '<' takes num or string type, found bool",
 		"1 and 2":      "line 1 column 3: 'and' takes bool type, found num",
 		"1 + false":    "line 1 column 3: mismatched type for +: num, bool",
@@ -217,6 +234,13 @@ func TestParseTopLevelExpressionErr(t *testing.T) {
 
 		"- 2":    "line 1 column 1: unexpected whitespace after '-'",
 		"! true": "line 1 column 1: unexpected whitespace after '!'",
+
+		"n1.(num)":     "line 1 column 3: value of type num cannot be type asserted",
+		"v.(foo)":      "line 1 column 4: invalid type in type assertion of 'v'",
+		"a == [1]":     "line 1 column 3: mismatched type for ==: num{}, num[]",
+		"a == {a:[1]}": "line 1 column 3: mismatched type for ==: num{}, num[]{}",
+		"v.(num":       "line 1 column 7: expected ')', got end of input",
+		"v.(num) + s":  "line 1 column 11: unknown variable name 's'",
 	}
 	for input, wantErr := range tests {
 		parser := New(input, testBuiltins())
@@ -225,6 +249,7 @@ func TestParseTopLevelExpressionErr(t *testing.T) {
 		scope.set("n1", &Var{Name: "n1", T: NUM_TYPE})
 		mapType := &Type{Name: MAP, Sub: NUM_TYPE}
 		scope.set("a", &Var{Name: "a", T: mapType})
+		scope.set("v", &Var{Name: "v", T: ANY_TYPE})
 
 		_ = parser.parseTopLevelExpr(scope)
 		assertParseError(t, parser, input)
@@ -13,7 +13,7 @@ import (
 	"foxygo.at/evy/pkg/lexer"
 )
 
-func Run(input string, builtins map[string]*FuncDecl) string {
+func Run(input string, builtins Builtins) string {
 	parser := New(input, builtins)
 	prog := parser.Parse()
 	if len(parser.errors) > 0 {
@@ -33,8 +33,10 @@ type Parser struct {
 	cur  *lexer.Token // current token under examination
 	peek *lexer.Token // next token after current token
 
-	tokens []*lexer.Token
-	funcs  map[string]*FuncDecl // all function declaration by name and index in tokens.
+	tokens        []*lexer.Token
+	funcs         map[string]*FuncDecl     // all function declaration by name and index in tokens.
+	globals       map[string]*Var          // builtin global variables, such as `error`
+	eventHandlers map[string]*EventHandler // all event handlers by event name
 
 	wssStack []bool
 }
@@ -49,9 +51,22 @@ func (e Error) String() string {
 	return e.token.Location() + ": " + e.message
 }
 
-func New(input string, builtins map[string]*FuncDecl) *Parser {
+// Builtins holds the declarations of all builtin functions and global
+// variables available to an evy program. Global variables are
+// read-only for evy programs.
+type Builtins struct {
+	Funcs   map[string]*FuncDecl
+	Globals map[string]*Var
+}
+
+func New(input string, builtins Builtins) *Parser {
 	l := lexer.New(input)
-	p := &Parser{funcs: builtins, wssStack: []bool{false}}
+	p := &Parser{
+		funcs:         builtins.Funcs,
+		globals:       builtins.Globals,
+		eventHandlers: map[string]*EventHandler{},
+		wssStack:      []bool{false},
+	}
 
 	// Read all tokens, collect function declaration tokens by index
 	// funcs temporarily holds FUNC token indices for further processing
@@ -59,9 +74,12 @@ func New(input string, builtins map[string]*FuncDecl) *Parser {
 	var token *lexer.Token
 	for token = l.Next(); token.Type != lexer.EOF; token = l.Next() {
 		if token.Type == lexer.ILLEGAL {
-			if token.Literal == `"` {
+			switch {
+			case token.Literal == `"`:
 				p.appendErrorForToken(`unterminated string, missing "`, token)
-			} else {
+			case strings.HasPrefix(token.Literal, `\`):
+				p.appendErrorForToken("invalid escape sequence '"+token.Literal+"' in string", token)
+			default:
 				p.appendErrorForToken("illegal character '"+token.Literal+"'", token)
 			}
 			continue
@@ -102,7 +120,7 @@ func (p *Parser) Parse() *Program {
 // in grammar doc/syntax_grammar.md.
 func (p *Parser) parseProgram() *Program {
 	program := &Program{}
-	scope := newScope(nil, program)
+	scope := newScope(p.builtinScope(program), program)
 	p.advanceTo(0)
 	for p.cur.TokenType() != lexer.EOF {
 		var stmt Node
@@ -127,9 +145,21 @@ func (p *Parser) parseProgram() *Program {
 		}
 	}
 	p.validateScope(scope)
+	program.EventHandlers = p.eventHandlers
 	return program
 }
 
+// builtinScope creates the outermost scope holding builtin global
+// variables.
+func (p *Parser) builtinScope(program *Program) *scope {
+	scope := newScope(nil, program)
+	for name, global := range p.globals {
+		v := &Var{Name: name, T: global.T, isUsed: true, readonly: true}
+		scope.set(name, v)
+	}
+	return scope
+}
+
 func (p *Parser) parseFunc(scope *scope) Node {
 	p.advance()  // advance past FUNC
 	tok := p.cur // function name
@@ -172,25 +202,55 @@ func (p *Parser) addParamsToScope(scope *scope, fd *FuncDecl) {
 		if _, ok := p.funcs[param.Name]; ok {
 			p.appendErrorForToken("invalid declaration of parameter '"+param.Name+"', already used as function name", param.Token)
 		}
-		scope.set(param.Name, param)
+		// Inside the function body the variadic parameter is an array.
+		arrType := &Type{Name: ARRAY, Sub: param.T}
+		scope.set(param.Name, &Var{Token: param.Token, Name: param.Name, T: arrType})
 	}
 }
 
+// eventParams holds the parameters of all supported events by event
+// name. Event parameters are implicitly declared, read-only variables
+// in the scope of the event handler, e.g. `key` in `on key_press`.
+var eventParams = map[string][]*Var{
+	"frame":      {{Name: "elapsed", T: NUM_TYPE}},
+	"key_press":  {{Name: "key", T: STRING_TYPE}},
+	"mouse_down": {{Name: "mouse_x", T: NUM_TYPE}, {Name: "mouse_y", T: NUM_TYPE}},
+	"mouse_up":   {{Name: "mouse_x", T: NUM_TYPE}, {Name: "mouse_y", T: NUM_TYPE}},
+	"mouse_move": {{Name: "mouse_x", T: NUM_TYPE}, {Name: "mouse_y", T: NUM_TYPE}},
+}
+
 func (p *Parser) parseEventHandler(scope *scope) Node {
+	e := &EventHandler{Token: p.cur}
 	p.advance() // advance past ON token
-	e := &EventHandler{}
 	if p.assertToken(lexer.IDENT) {
 		e.Name = p.cur.Literal
+		if _, ok := eventParams[e.Name]; !ok {
+			p.appendError("unknown event '" + e.Name + "'")
+		} else if _, ok := p.eventHandlers[e.Name]; ok {
+			p.appendError("redeclaration of 'on " + e.Name + "'")
+		} else {
+			p.eventHandlers[e.Name] = e
+		}
 		p.advance() // advance past event name IDENT
 		p.assertEOL()
 	}
 	p.advancePastNL() // advance past `on EVENT_NAME`
+	scope = newScopeWithReturnType(scope, e, NONE_TYPE)
+	p.addEventParamsToScope(scope, e)
 	e.Body = p.parseBlock(scope)
 	p.assertEnd()
 	p.advancePastNL()
 	return e
 }
 
+func (p *Parser) addEventParamsToScope(scope *scope, e *EventHandler) {
+	for _, param := range eventParams[e.Name] {
+		v := &Var{Token: e.Token, Name: param.Name, T: param.T, isUsed: true, readonly: true}
+		e.Params = append(e.Params, v)
+		scope.set(v.Name, v)
+	}
+}
+
 func (p *Parser) parseStatement(scope *scope) Node {
 	switch p.cur.TokenType() {
 	// empty statement
@@ -271,6 +331,10 @@ func (p *Parser) parseAssignmentTarget(scope *scope) Node {
 		p.appendErrorForToken("unknown variable name '"+name+"'", tok)
 		return nil
 	}
+	if v.readonly {
+		p.appendErrorForToken("cannot assign to read-only variable '"+name+"'", tok)
+		return nil
+	}
 	v.isUsed = true
 	tt := p.cur.TokenType()
 	var n Node = v
@@ -285,8 +349,15 @@ func (p *Parser) parseAssignmentTarget(scope *scope) Node {
 		if p.cur.TokenType() == lexer.DOT {
 			n = p.parseDotExpr(n)
 		}
+		if n == nil {
+			return nil
+		}
 		tt = p.cur.TokenType()
 	}
+	if _, ok := n.(*TypeAssertion); ok {
+		p.appendErrorForToken("cannot assign to type assertion", tok)
+		return nil
+	}
 	return n
 }
 
@@ -426,12 +497,19 @@ func (p *Parser) assertArgTypes(decl *FuncDecl, args []Node) {
 		p.appendError("'" + funcName + "' takes " + quantify(len(decl.Params), "argument") + ", found " + strconv.Itoa(len(args)))
 		return
 	}
+	var arrType *Type // type of the last GENERIC_ARRAY argument
 	for i := range args {
 		paramType := decl.Params[i].Type()
 		argType := args[i].Type()
+		if paramType == GENERIC_ELEMENT && arrType != nil && arrType != GENERIC_ARRAY {
+			paramType = arrType.Sub
+		}
 		if !paramType.Accepts(argType) && !paramType.Matches(argType) {
 			p.appendError("'" + funcName + "' takes " + ordinalize(i+1) + " argument of type '" + paramType.Format() + "', found '" + argType.Format() + "'")
 		}
+		if paramType == GENERIC_ARRAY && argType.Name == ARRAY {
+			arrType = argType
+		}
 	}
 }
 
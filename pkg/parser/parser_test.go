@@ -30,6 +30,7 @@ func TestParseDeclaration(t *testing.T) {
 		"a := {a:1 b:true}":                  {"a={a:1, b:true}"},
 		"a := {a:1 b:true c:[1]}":            {"a={a:1, b:true, c:[1]}"},
 		"a := [{a:1}]":                       {"a=[{a:1}]"},
+		`a := {"a b":1 "if":2 c:3}`:          {"a={a b:1, if:2, c:3}"},
 	}
 	for input, wantSlice := range tests {
 		input += "\n print a"
@@ -79,6 +80,8 @@ func TestParseDeclarationError(t *testing.T) {
 		"a := {}[":      "line 1 column 9: unexpected end of input",
 		"a :num num":    "line 1 column 8: expected end of line, found 'num'",
 		"a :num{}num":   "line 1 column 7: expected end of line, found '{'",
+		`a := "\q"`:     "line 1 column 7: invalid escape sequence '\\q' in string",
+		`a := "\u{zz}"`: "line 1 column 7: invalid escape sequence '\\u{' in string",
 		`
 m := {name: "Greta"}
 s := name
@@ -105,6 +108,19 @@ func TestFunctionCall(t *testing.T) {
 		`a:=true
 		b:string
 		print a b`: {"a=true", "b=''", "print(a, b)"},
+		`a := [1 2]
+		print a...`: {"a=[1, 2]", "print(a...)"},
+		`print []...`:        {"print([]...)"},
+		`print [[1] [2]]...`: {"print([[1], [2]]...)"},
+		`a := [1]
+		append a 2`: {"a=[1]", "append(a, 2)"},
+		`a:[]any
+		append a "x"`: {"a=[]", "append(a, 'x')"},
+		`a := [[1]]
+		append a [2 3]`: {"a=[[1]]", "append(a, [2, 3])"},
+		`a := [[1]]
+		append a []`: {"a=[[1]]", "append(a, [])"},
+		`append [] true`: {"append([], true)"},
 	}
 	for input, wantSlice := range tests {
 		want := strings.Join(wantSlice, "\n") + "\n"
@@ -117,26 +133,42 @@ func TestFunctionCall(t *testing.T) {
 
 func TestFunctionCallError(t *testing.T) {
 	builtins := testBuiltins()
-	builtins["f0"] = &FuncDecl{Name: "f0", ReturnType: NONE_TYPE}
-	builtins["f1"] = &FuncDecl{Name: "f1", VariadicParam: &Var{Name: "a", T: NUM_TYPE}, ReturnType: NONE_TYPE}
-	builtins["f2"] = &FuncDecl{Name: "f2", Params: []*Var{{Name: "a", T: NUM_TYPE}}, ReturnType: NONE_TYPE}
-	builtins["f3"] = &FuncDecl{
+	funcs := builtins.Funcs
+	funcs["f0"] = &FuncDecl{Name: "f0", ReturnType: NONE_TYPE}
+	funcs["f1"] = &FuncDecl{Name: "f1", VariadicParam: &Var{Name: "a", T: NUM_TYPE}, ReturnType: NONE_TYPE}
+	funcs["f2"] = &FuncDecl{Name: "f2", Params: []*Var{{Name: "a", T: NUM_TYPE}}, ReturnType: NONE_TYPE}
+	funcs["f3"] = &FuncDecl{
 		Name:       "f3",
 		Params:     []*Var{{Name: "a", T: NUM_TYPE}, {Name: "b", T: STRING_TYPE}},
 		ReturnType: NONE_TYPE,
 	}
+	funcs["f4"] = &FuncDecl{Name: "f4", Params: []*Var{{Name: "a", T: STRING_OR_NUM}}, ReturnType: NONE_TYPE}
 	tests := map[string]string{
-		`len 2 2`:    "line 1 column 8: 'len' takes 1 argument, found 2",
-		`len`:        "line 1 column 4: 'len' takes 1 argument, found 0",
-		`a := print`: "line 1 column 11: invalid declaration, function 'print' has no return value",
-		`a := f0`:    "line 1 column 8: invalid declaration, function 'f0' has no return value",
-		`f0 "arg"`:   "line 1 column 9: 'f0' takes 0 arguments, found 1",
-		`f2`:         "line 1 column 3: 'f2' takes 1 argument, found 0",
-		`f2 f1`:      "line 1 column 4: function call must be parenthesized: (f1 ...)",
-		`f1 "arg"`:   "line 1 column 9: 'f1' takes variadic arguments of type 'num', found 'string'",
-		`f3 1 2`:     "line 1 column 7: 'f3' takes 2nd argument of type 'string', found 'num'",
-		`f3 "1" "2"`: "line 1 column 11: 'f3' takes 1st argument of type 'num', found 'string'",
-		`foo 0`:      "line 1 column 1: unknown function 'foo'",
+		`len 2 2`:             "line 1 column 8: 'len' takes 1 argument, found 2",
+		`len`:                 "line 1 column 4: 'len' takes 1 argument, found 0",
+		`a := print`:          "line 1 column 11: invalid declaration, function 'print' has no return value",
+		`a := f0`:             "line 1 column 8: invalid declaration, function 'f0' has no return value",
+		`f0 "arg"`:            "line 1 column 9: 'f0' takes 0 arguments, found 1",
+		`f2`:                  "line 1 column 3: 'f2' takes 1 argument, found 0",
+		`f2 f1`:               "line 1 column 4: function call must be parenthesized: (f1 ...)",
+		`f1 "arg"`:            "line 1 column 9: 'f1' takes variadic arguments of type 'num', found 'string'",
+		`f3 1 2`:              "line 1 column 7: 'f3' takes 2nd argument of type 'string', found 'num'",
+		`f3 "1" "2"`:          "line 1 column 11: 'f3' takes 1st argument of type 'num', found 'string'",
+		`foo 0`:               "line 1 column 1: unknown function 'foo'",
+		`f1 ["a"]...`:         "line 1 column 9: 'f1' takes variadic arguments of type 'num', found 'string[]...'",
+		`f1 [[1]]...`:         "line 1 column 9: 'f1' takes variadic arguments of type 'num', found 'num[][]...'",
+		`f1 "a"...`:           "line 1 column 7: '...' expects array argument, found string",
+		`f1 [1] [2]...`:       "line 1 column 11: '...' can only be used with a single argument, found 2",
+		`f2 [1]...`:           "line 1 column 7: '...' can only be used with variadic function, 'f2' is not variadic",
+		`f1 [1] ...`:          "line 1 column 8: unexpected whitespace before '...'",
+		`f1 ...`:              "line 1 column 4: unexpected whitespace before '...'",
+		`append [1] "a"`:      "line 1 column 15: 'append' takes 2nd argument of type 'num', found 'string'",
+		`append [[1]] [true]`: "line 1 column 20: 'append' takes 2nd argument of type 'num[]', found 'bool[]'",
+		`append 1 2`:          "line 1 column 11: 'append' takes 1st argument of type '[]', found 'num'",
+		`append [1] 2 3`:      "line 1 column 15: 'append' takes 2 arguments, found 3",
+		`f4 true`:             "line 1 column 8: 'f4' takes 1st argument of type 'string or num', found 'bool'",
+		`f4 [1]`:              "line 1 column 7: 'f4' takes 1st argument of type 'string or num', found 'num[]'",
+		"x:any\nf4 x":         "line 2 column 5: 'f4' takes 1st argument of type 'string or num', found 'any'",
 	}
 	for input, err1 := range tests {
 		parser := New(input, builtins)
@@ -202,7 +234,7 @@ func nums1:num n1:num n2:num
 	end
 	return n2
 end
-on mousedown
+on mouse_down
 	if c > 10
 	    print c
 	end
@@ -232,7 +264,7 @@ end
 	parser := New(input, testBuiltins())
 	_ = parser.Parse()
 	assertNoParseError(t, parser, input)
-	builtinCnt := len(testBuiltins())
+	builtinCnt := len(testBuiltins().Funcs)
 	assert.Equal(t, builtinCnt+4, len(parser.funcs))
 	got := parser.funcs["nums1"]
 	assert.Equal(t, "nums1", got.Name)
@@ -385,6 +417,10 @@ b:any
 a = b
 `: "line 4 column 1: 'a' accepts values of type num, found any",
 		`
+b:any
+b.(num) = 1
+`: "line 3 column 1: cannot assign to type assertion",
+		`
 func fn:bool
 	return true
 end
@@ -957,6 +993,106 @@ end
 	}
 }
 
+func TestEventHandler(t *testing.T) {
+	input := `
+on mouse_down
+	print "down"
+end
+on key_press
+	print "key" key
+end
+on frame
+	print "frame"
+end`
+	parser := New(input, testBuiltins())
+	got := parser.Parse()
+	assertNoParseError(t, parser, input)
+	assert.Equal(t, 3, len(got.EventHandlers))
+	assert.Equal(t, "on mouse_down {\nprint('down')\n}\n", got.EventHandlers["mouse_down"].String())
+	assert.Equal(t, "on key_press {\nprint('key', key)\n}\n", got.EventHandlers["key_press"].String())
+	params := got.EventHandlers["mouse_down"].Params
+	assert.Equal(t, 2, len(params))
+	assert.Equal(t, "mouse_x", params[0].Name)
+	assert.Equal(t, NUM_TYPE, params[0].Type())
+	assert.Equal(t, "mouse_y", params[1].Name)
+	params = got.EventHandlers["key_press"].Params
+	assert.Equal(t, 1, len(params))
+	assert.Equal(t, "key", params[0].Name)
+	assert.Equal(t, STRING_TYPE, params[0].Type())
+	params = got.EventHandlers["frame"].Params
+	assert.Equal(t, 1, len(params))
+	assert.Equal(t, "elapsed", params[0].Name)
+	assert.Equal(t, NUM_TYPE, params[0].Type())
+}
+
+func TestEventHandlerErr(t *testing.T) {
+	tests := map[string]string{
+		`
+on mouse_down
+	print "1"
+end
+on mouse_down
+	print "2"
+end`: "line 5 column 4: redeclaration of 'on mouse_down'",
+		`
+on mouse_down
+	return 1
+end`: "line 3 column 9: expected no return value, found num",
+		`
+on mousedown
+end`: "line 2 column 4: unknown event 'mousedown'",
+		`
+on key_press
+	key = "a"
+end`: "line 3 column 2: cannot assign to read-only variable 'key'",
+		`
+on mouse_up
+	mouse_x := 1
+	print mouse_x
+end`: "line 3 column 2: redeclaration of 'mouse_x'",
+		`
+on mouse_up
+	print "up"
+end
+print mouse_x`: "line 5 column 7: unknown variable name 'mouse_x'",
+	}
+	for input, wantErr := range tests {
+		parser := New(input, testBuiltins())
+		_ = parser.Parse()
+		assertParseError(t, parser, input)
+		assert.Equal(t, wantErr, parser.MaxErrorsString(1), "input: %s\nerrors:\n%s", input, parser.ErrorsString())
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	tests := map[string]string{
+		"print err":               "print(err)\n",
+		"x := err\nprint x":       "x=err\nprint(x)\n",
+		"func f\n print err\nend": "f(){\nprint(err)\n}\n\n",
+		"err := 1\nprint err":     "err=1\nprint(err)\n",
+	}
+	for input, want := range tests {
+		parser := New(input, testBuiltins())
+		got := parser.Parse()
+		assertNoParseError(t, parser, input)
+		assert.Equal(t, want, got.String())
+	}
+}
+
+func TestGlobalsErr(t *testing.T) {
+	tests := map[string]string{
+		`err = "x"`:                 "line 1 column 1: cannot assign to read-only variable 'err'",
+		"func f\n err = \"x\"\nend": "line 2 column 2: cannot assign to read-only variable 'err'",
+		"n := err + 1":              "line 1 column 10: mismatched type for +: string, num",
+	}
+	for input, wantErr := range tests {
+		parser := New(input, testBuiltins())
+		_ = parser.Parse()
+		assertParseError(t, parser, input)
+		assert.Equal(t, wantErr, parser.MaxErrorsString(1), "input: %s\nerrors:\n%s", input, parser.ErrorsString())
+	}
+}
+
 func TestDemo(t *testing.T) {
 	input := `
 move 10 10
@@ -992,8 +1128,8 @@ func assertNoParseError(t *testing.T, parser *Parser, input string) {
 	assert.Equal(t, 0, len(parser.errors), "Unexpected parser error\n input: %s\nerrors:\n%s", input, parser.ErrorsString())
 }
 
-func testBuiltins() map[string]*FuncDecl {
-	return map[string]*FuncDecl{
+func testBuiltins() Builtins {
+	funcs := map[string]*FuncDecl{
 		"print": {
 			Name:          "print",
 			VariadicParam: &Var{Name: "a", T: ANY_TYPE},
@@ -1004,5 +1140,14 @@ func testBuiltins() map[string]*FuncDecl {
 			Params:     []*Var{{Name: "a", T: ANY_TYPE}},
 			ReturnType: NUM_TYPE,
 		},
+		"append": {
+			Name:       "append",
+			Params:     []*Var{{Name: "arr", T: GENERIC_ARRAY}, {Name: "val", T: GENERIC_ELEMENT}},
+			ReturnType: NONE_TYPE,
+		},
+	}
+	globals := map[string]*Var{
+		"err": {Name: "err", T: STRING_TYPE},
 	}
+	return Builtins{Funcs: funcs, Globals: globals}
 }
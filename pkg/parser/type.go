@@ -26,6 +26,14 @@ var (
 	NONE_TYPE     = &Type{Name: NONE}
 	GENERIC_ARRAY = &Type{Name: ARRAY, Sub: NONE_TYPE}
 	GENERIC_MAP   = &Type{Name: MAP, Sub: NONE_TYPE}
+	// GENERIC_ELEMENT is a builtin function parameter type standing in
+	// for the element type of the preceding GENERIC_ARRAY argument, e.g.
+	// for `append arr val`.
+	GENERIC_ELEMENT = &Type{Name: ANY}
+	// STRING_OR_NUM is a builtin function parameter type accepting
+	// string and num arguments only, e.g. for `color "red"` and
+	// `color 900`.
+	STRING_OR_NUM = &Type{Name: ANY}
 )
 
 func compositeTypeName(t lexer.TokenType) TypeName {
@@ -85,6 +93,9 @@ func (t *Type) String() string {
 }
 
 func (t *Type) Format() string {
+	if t == STRING_OR_NUM {
+		return "string or num"
+	}
 	if t.Sub == nil || t == GENERIC_ARRAY || t == GENERIC_MAP {
 		return t.Name.Format()
 	}
@@ -92,6 +103,9 @@ func (t *Type) Format() string {
 }
 
 func (t *Type) Accepts(t2 *Type) bool {
+	if t == STRING_OR_NUM {
+		return t2 == STRING_TYPE || t2 == NUM_TYPE
+	}
 	if t.acceptsStrict(t2) {
 		return true
 	}
@@ -108,6 +122,9 @@ func (t *Type) Matches(t2 *Type) bool {
 	if t == t2 {
 		return true
 	}
+	if t == STRING_OR_NUM || t2 == STRING_OR_NUM {
+		return false
+	}
 	if t.Name != t2.Name {
 		return false
 	}
@@ -123,6 +140,26 @@ func (t *Type) Matches(t2 *Type) bool {
 	return t.Sub.Matches(t2.Sub)
 }
 
+// comparable reports whether values of type t and t2 can be compared
+// with `==` and `!=`. In contrast to Matches, any is comparable with
+// all types, also as sub type, e.g. {}any and {}string are comparable.
+func (t *Type) comparable(t2 *Type) bool {
+	if t.Matches(t2) {
+		return true
+	}
+	n, n2 := t.Name, t2.Name
+	if n == ILLEGAL || n2 == ILLEGAL || n == NONE || n2 == NONE {
+		return false
+	}
+	if n == ANY || n2 == ANY {
+		return true
+	}
+	if n != n2 || t.Sub == nil || t2.Sub == nil {
+		return false
+	}
+	return t.Sub.comparable(t2.Sub)
+}
+
 func (t *Type) Infer() *Type {
 	if t.Name != ARRAY && t.Name != MAP {
 		return t
@@ -0,0 +1,28 @@
+package raster
+
+import (
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// parseColor parses hex colours of the forms #rrggbb and #rrggbbaa, as
+// normalised by the evaluator.
+func parseColor(s string) (color.NRGBA, bool) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == len(s) {
+		return color.NRGBA{}, false
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	if len(hex) != 8 {
+		return color.NRGBA{}, false
+	}
+	n, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, false
+	}
+	c := color.NRGBA{R: uint8(n >> 24), G: uint8(n >> 16), B: uint8(n >> 8), A: uint8(n)}
+	return c, true
+}
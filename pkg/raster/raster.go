@@ -0,0 +1,361 @@
+// Package raster implements the evy graphics builtins by drawing into
+// an in-memory image, which can be written as PNG. It uses the same
+// coordinate system as the browser canvas: by default 100 by 100 units
+// with the origin at the bottom left and the y-axis pointing up.
+//
+// Shapes are drawn without anti-aliasing: a pixel is painted if its
+// centre lies inside the shape. Text is not supported.
+package raster
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+
+	"foxygo.at/evy/pkg/evaluator"
+)
+
+const (
+	size = 100 // default width and height of the drawing in evy units
+
+	defaultWidth  = 0.1 // line width, 1 pixel on the browser canvas
+	curveSegments = 32  // number of line segments approximating a curve
+)
+
+// Graphics draws the graphics builtins of an evy program into an
+// image.
+type Graphics struct {
+	x, y float64 // pen position in pixels
+	state
+	saved []state
+	img   *image.RGBA
+	size  float64 // width and height of img in pixels
+}
+
+// state is the drawing state saved and restored by Save and Restore:
+// colours, line width and coordinate system. Transparent colours, e.g.
+// "none", are not drawn.
+type state struct {
+	stroke color.NRGBA
+	fill   color.NRGBA
+	width  float64 // in pixels
+
+	// pixel x = (x + offsetX) * scaleX, likewise for y.
+	scaleX, scaleY   float64
+	offsetX, offsetY float64
+}
+
+type point struct {
+	x, y float64
+}
+
+// New returns a new Graphics drawing into a transparent image of
+// pixels by pixels size, with black stroke and fill colour and the
+// pen at the origin.
+func New(pixels int) *Graphics {
+	black := color.NRGBA{A: 255}
+	g := &Graphics{
+		state: state{stroke: black, fill: black},
+		img:   image.NewRGBA(image.Rect(0, 0, pixels, pixels)),
+		size:  float64(pixels),
+	}
+	g.Coordinates(0, 0, size, size)
+	g.Width(defaultWidth)
+	g.Move(0, 0)
+	return g
+}
+
+// Runtime returns the graphics runtime for evaluator.Runtime, drawing
+// to g.
+func (g *Graphics) Runtime() evaluator.GraphicsRuntime {
+	return evaluator.GraphicsRuntime{
+		Move:        g.Move,
+		Line:        g.Line,
+		Rect:        g.Rect,
+		Circle:      g.Circle,
+		Width:       g.Width,
+		Color:       g.Color,
+		Stroke:      g.Stroke,
+		Fill:        g.Fill,
+		Polygon:     g.Polygon,
+		Polyline:    g.Polyline,
+		Curve:       g.Curve,
+		Clear:       g.Clear,
+		Save:        g.Save,
+		Restore:     g.Restore,
+		Coordinates: g.Coordinates,
+	}
+}
+
+// Image returns the image drawn to.
+func (g *Graphics) Image() *image.RGBA {
+	return g.img
+}
+
+// WriteTo writes the image as PNG to w, implementing io.WriterTo.
+func (g *Graphics) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	err := png.Encode(cw, g.img)
+	return cw.n, err
+}
+
+func (g *Graphics) Move(x, y float64) {
+	p := g.pixel(x, y)
+	g.x, g.y = p.x, p.y
+}
+
+func (g *Graphics) Line(x, y float64) {
+	g.strokePath([]point{{g.x, g.y}, g.pixel(x, y)}, false)
+	g.Move(x, y)
+}
+
+func (g *Graphics) Rect(dx, dy float64) {
+	p1 := point{g.x, g.y}
+	p2 := point{g.x + dx*g.scaleX, g.y + dy*g.scaleY}
+	minX, maxX := math.Min(p1.x, p2.x), math.Max(p1.x, p2.x)
+	minY, maxY := math.Min(p1.y, p2.y), math.Max(p1.y, p2.y)
+	g.paint(bounds([]point{p1, p2}, 0), g.fill, func(p point) bool {
+		return p.x >= minX && p.x <= maxX && p.y >= minY && p.y <= maxY
+	})
+	g.strokePath([]point{p1, {p2.x, p1.y}, p2, {p1.x, p2.y}}, true)
+	g.x, g.y = p2.x, p2.y
+}
+
+func (g *Graphics) Circle(radius float64) {
+	c := point{g.x, g.y}
+	r := g.pixelLen(radius)
+	box := bounds([]point{{c.x - r, c.y - r}, {c.x + r, c.y + r}}, 0)
+	g.paint(box, g.fill, func(p point) bool {
+		return dist(p, c) <= r
+	})
+	hw := g.halfWidth()
+	g.paint(box.Inset(-int(math.Ceil(hw))), g.stroke, func(p point) bool {
+		return math.Abs(dist(p, c)-r) <= hw
+	})
+}
+
+func (g *Graphics) Width(w float64) {
+	g.width = g.pixelLen(w)
+}
+
+// Color sets stroke and fill colour. Invalid colours are ignored,
+// keeping the previous colour, like on the browser canvas.
+func (g *Graphics) Color(s string) {
+	g.Stroke(s)
+	g.Fill(s)
+}
+
+func (g *Graphics) Stroke(s string) {
+	if c, ok := parseDrawColor(s); ok {
+		g.stroke = c
+	}
+}
+
+func (g *Graphics) Fill(s string) {
+	if c, ok := parseDrawColor(s); ok {
+		g.fill = c
+	}
+}
+
+func (g *Graphics) Polygon(vertices [][]float64) {
+	pts := g.pixels(vertices)
+	g.paint(bounds(pts, 0), g.fill, func(p point) bool {
+		return insidePolygon(p, pts)
+	})
+	g.strokePath(pts, true)
+}
+
+func (g *Graphics) Polyline(vertices [][]float64) {
+	g.strokePath(g.pixels(vertices), false)
+}
+
+// Curve draws a quadratic Bézier curve from the current position to
+// (x, y) with control point (cx, cy), approximated by line segments.
+func (g *Graphics) Curve(cx, cy, x, y float64) {
+	p0, p1, p2 := point{g.x, g.y}, g.pixel(cx, cy), g.pixel(x, y)
+	pts := make([]point, curveSegments+1)
+	for i := range pts {
+		t := float64(i) / curveSegments
+		a, b, c := (1-t)*(1-t), 2*(1-t)*t, t*t
+		pts[i] = point{a*p0.x + b*p1.x + c*p2.x, a*p0.y + b*p1.y + c*p2.y}
+	}
+	g.strokePath(pts, false)
+	g.Move(x, y)
+}
+
+// strokePath draws lines of the current width and stroke colour
+// through pts, back to the first point if closed.
+func (g *Graphics) strokePath(pts []point, closed bool) {
+	if len(pts) == 0 {
+		return
+	}
+	if closed {
+		pts = append(pts, pts[0])
+	}
+	hw := g.halfWidth()
+	g.paint(bounds(pts, hw), g.stroke, func(p point) bool {
+		if len(pts) == 1 {
+			return dist(p, pts[0]) <= hw
+		}
+		for i := 1; i < len(pts); i++ {
+			if distSegment(p, pts[i-1], pts[i]) <= hw {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// halfWidth returns half the line width in pixels, at least half a
+// pixel so that thin lines remain visible.
+func (g *Graphics) halfWidth() float64 {
+	return math.Max(g.width/2, 0.5)
+}
+
+// paint blends c into all pixels in box whose centre is inside the
+// shape.
+func (g *Graphics) paint(box image.Rectangle, c color.NRGBA, inside func(p point) bool) {
+	if c.A == 0 {
+		return
+	}
+	box = box.Intersect(g.img.Bounds())
+	for y := box.Min.Y; y < box.Max.Y; y++ {
+		for x := box.Min.X; x < box.Max.X; x++ {
+			if inside(point{float64(x) + 0.5, float64(y) + 0.5}) {
+				g.blend(x, y, c)
+			}
+		}
+	}
+}
+
+// blend paints c over the pixel at x, y using alpha compositing.
+func (g *Graphics) blend(x, y int, c color.NRGBA) {
+	if c.A == 255 {
+		g.img.SetRGBA(x, y, color.RGBA{R: c.R, G: c.G, B: c.B, A: 255})
+		return
+	}
+	dst := g.img.RGBAAt(x, y)
+	a := uint32(c.A)
+	over := func(src, dst uint8) uint8 {
+		return uint8((uint32(src)*a + uint32(dst)*(255-a)) / 255)
+	}
+	g.img.SetRGBA(x, y, color.RGBA{
+		R: over(c.R, dst.R),
+		G: over(c.G, dst.G),
+		B: over(c.B, dst.B),
+		A: uint8(a + uint32(dst.A)*(255-a)/255),
+	})
+}
+
+// Clear makes all pixels transparent.
+func (g *Graphics) Clear() {
+	for i := range g.img.Pix {
+		g.img.Pix[i] = 0
+	}
+}
+
+func (g *Graphics) Save() {
+	g.saved = append(g.saved, g.state)
+}
+
+// Restore restores the most recently saved drawing state. It does
+// nothing if there is no saved state, like on the browser canvas.
+func (g *Graphics) Restore() {
+	if len(g.saved) == 0 {
+		return
+	}
+	g.state = g.saved[len(g.saved)-1]
+	g.saved = g.saved[:len(g.saved)-1]
+}
+
+// Coordinates sets the coordinate system to span minX to maxX and minY
+// to maxY, with the y-axis pointing up if minY < maxY.
+func (g *Graphics) Coordinates(minX, minY, maxX, maxY float64) {
+	g.scaleX = g.size / (maxX - minX)
+	g.scaleY = -g.size / (maxY - minY)
+	g.offsetX = -minX
+	g.offsetY = -maxY
+}
+
+// pixel converts evy coordinates to pixel coordinates.
+func (g *Graphics) pixel(x, y float64) point {
+	return point{(x + g.offsetX) * g.scaleX, (y + g.offsetY) * g.scaleY}
+}
+
+// pixelLen converts a length, e.g. a radius, to pixels, using the
+// horizontal scale.
+func (g *Graphics) pixelLen(l float64) float64 {
+	return math.Abs(l * g.scaleX)
+}
+
+func (g *Graphics) pixels(vertices [][]float64) []point {
+	pts := make([]point, len(vertices))
+	for i, v := range vertices {
+		pts[i] = g.pixel(v[0], v[1])
+	}
+	return pts
+}
+
+// parseDrawColor parses s as hex colour; "none" is transparent.
+func parseDrawColor(s string) (color.NRGBA, bool) {
+	if s == "none" {
+		return color.NRGBA{}, true
+	}
+	return parseColor(s)
+}
+
+// bounds returns the bounding box of pts, extended by margin.
+func bounds(pts []point, margin float64) image.Rectangle {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, p := range pts {
+		minX, maxX = math.Min(minX, p.x), math.Max(maxX, p.x)
+		minY, maxY = math.Min(minY, p.y), math.Max(maxY, p.y)
+	}
+	return image.Rect(
+		int(math.Floor(minX-margin)), int(math.Floor(minY-margin)),
+		int(math.Ceil(maxX+margin)), int(math.Ceil(maxY+margin)),
+	)
+}
+
+// insidePolygon reports whether p is inside the polygon pts, using the
+// even-odd rule as the browser canvas.
+func insidePolygon(p point, pts []point) bool {
+	inside := false
+	for i, j := 0, len(pts)-1; i < len(pts); j, i = i, i+1 {
+		a, b := pts[i], pts[j]
+		if (a.y > p.y) != (b.y > p.y) && p.x < (b.x-a.x)*(p.y-a.y)/(b.y-a.y)+a.x {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+func dist(p, q point) float64 {
+	return math.Hypot(p.x-q.x, p.y-q.y)
+}
+
+// distSegment returns the distance of p to the line segment a b.
+func distSegment(p, a, b point) float64 {
+	dx, dy := b.x-a.x, b.y-a.y
+	l2 := dx*dx + dy*dy
+	if l2 == 0 {
+		return dist(p, a)
+	}
+	t := ((p.x-a.x)*dx + (p.y-a.y)*dy) / l2
+	t = math.Max(0, math.Min(1, t))
+	return dist(p, point{a.x + t*dx, a.y + t*dy})
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
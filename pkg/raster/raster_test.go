@@ -0,0 +1,158 @@
+package raster
+
+import (
+	"bytes"
+	"flag"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"foxygo.at/evy/pkg/assert"
+	"foxygo.at/evy/pkg/evaluator"
+)
+
+var update = flag.Bool("update", false, "update golden PNG files in testdata")
+
+// TestGolden renders all evy programs in testdata and compares them
+// pixel by pixel with the PNG files of the same name. Run
+//
+//	go test ./pkg/raster -update
+//
+// to regenerate the PNG files after intentional changes.
+func TestGolden(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.evy")
+	assert.NoError(t, err)
+	assert.Equal(t, true, len(files) > 0)
+	for _, file := range files {
+		file := file
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			prog, err := os.ReadFile(file)
+			assert.NoError(t, err)
+			g := New(200)
+			rt := evaluator.Runtime{Print: func(s string) { t.Log(s) }, Graphics: g.Runtime()}
+			err = evaluator.RunWithBuiltinsErr(string(prog), evaluator.DefaultBuiltins(rt))
+			assert.NoError(t, err)
+
+			goldenFile := strings.TrimSuffix(file, ".evy") + ".png"
+			if *update {
+				var buf bytes.Buffer
+				_, err := g.WriteTo(&buf)
+				assert.NoError(t, err)
+				assert.NoError(t, os.WriteFile(goldenFile, buf.Bytes(), 0o666))
+			}
+			f, err := os.Open(goldenFile)
+			assert.NoError(t, err)
+			defer f.Close()
+			golden, err := png.Decode(f)
+			assert.NoError(t, err)
+			assert.Equal(t, 0, diffPixels(golden, g.Image()), "differing pixels in "+goldenFile)
+		})
+	}
+}
+
+// diffPixels returns the number of pixels that differ between a and b.
+func diffPixels(a, b image.Image) int {
+	if a.Bounds() != b.Bounds() {
+		return a.Bounds().Dx() * a.Bounds().Dy()
+	}
+	n := 0
+	r := a.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			// compare non-premultiplied as stored in PNG files
+			if color.NRGBAModel.Convert(a.At(x, y)) != color.NRGBAModel.Convert(b.At(x, y)) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func TestPixels(t *testing.T) {
+	g := New(10)
+	g.Color("#ff0000")
+	g.Stroke("none")
+	g.Move(0, 100)
+	g.Rect(50, -50) // top left quarter
+	g.Fill("#0000ff80")
+	g.Move(20, 0)
+	g.Rect(40, 60) // transparent blue over red and transparent
+	img := g.Image()
+	tests := []struct {
+		x, y int
+		want color.NRGBA
+	}{
+		{x: 0, y: 0, want: color.NRGBA{R: 255, A: 255}},
+		{x: 1, y: 4, want: color.NRGBA{R: 255, A: 255}},
+		{x: 7, y: 2, want: color.NRGBA{}},
+		{x: 9, y: 9, want: color.NRGBA{}},
+		{x: 3, y: 7, want: color.NRGBA{B: 255, A: 128}},
+		{x: 3, y: 4, want: color.NRGBA{R: 127, B: 128, A: 255}},
+	}
+	for _, tt := range tests {
+		got := color.NRGBAModel.Convert(img.At(tt.x, tt.y))
+		assert.Equal(t, tt.want, got, "pixel "+strconv.Itoa(tt.x)+","+strconv.Itoa(tt.y))
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	tests := map[string]color.NRGBA{
+		"#ff0000":   {R: 255, A: 255},
+		"#ff000080": {R: 255, A: 128},
+		"#1e90ff":   {R: 30, G: 144, B: 255, A: 255},
+	}
+	for s, want := range tests {
+		got, ok := parseColor(s)
+		assert.Equal(t, true, ok, s)
+		assert.Equal(t, want, got, s)
+	}
+	for _, s := range []string{"red", "#f00", "ff0000", "#gggggg", ""} {
+		_, ok := parseColor(s)
+		assert.Equal(t, false, ok, s)
+	}
+}
+
+func TestInvalidColorIgnored(t *testing.T) {
+	g := New(10)
+	g.Color("#ff0000")
+	g.Color("red")
+	assert.Equal(t, color.NRGBA{R: 255, A: 255}, g.fill)
+	assert.Equal(t, color.NRGBA{R: 255, A: 255}, g.stroke)
+}
+
+func TestStateAndCoordinates(t *testing.T) {
+	g := New(10)
+	g.Color("#ff0000")
+	g.Line(100, 100)
+	g.Clear()
+	g.Save()
+	g.Coordinates(-1, -1, 1, 1)
+	g.Color("#0000ff")
+	g.Stroke("none")
+	g.Move(0, 0)
+	g.Rect(1, 1) // top right quarter
+	g.Restore()
+	g.Stroke("none")
+	g.Move(0, 0)
+	g.Rect(10, 10) // bottom left corner in default coordinates
+	g.Restore()    // no saved state, ignored
+	img := g.Image()
+	tests := []struct {
+		x, y int
+		want color.NRGBA
+	}{
+		{x: 3, y: 6, want: color.NRGBA{}}, // cleared line
+		{x: 7, y: 2, want: color.NRGBA{B: 255, A: 255}},
+		{x: 2, y: 7, want: color.NRGBA{}},
+		{x: 0, y: 9, want: color.NRGBA{R: 255, A: 255}},
+	}
+	for _, tt := range tests {
+		got := color.NRGBAModel.Convert(img.At(tt.x, tt.y))
+		assert.Equal(t, tt.want, got, "pixel "+strconv.Itoa(tt.x)+","+strconv.Itoa(tt.y))
+	}
+}
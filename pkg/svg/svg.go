@@ -0,0 +1,254 @@
+// Package svg implements the evy graphics builtins by recording
+// drawing commands as SVG elements. It uses the same coordinate system
+// as the browser canvas: by default 100 by 100 units with the origin at
+// the bottom left and the y-axis pointing up.
+package svg
+
+import (
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"foxygo.at/evy/pkg/evaluator"
+)
+
+const (
+	size = 100 // width and height of the SVG viewBox
+
+	defaultWidth    = 0.1 // line width, 1 pixel on the browser canvas
+	defaultTextSize = 6
+)
+
+// Graphics records the drawing commands of an evy program and writes
+// them as SVG document with WriteTo.
+type Graphics struct {
+	x, y float64 // pen position in viewBox units
+	state
+	saved    []state
+	elements []string
+}
+
+// state is the drawing state saved and restored by Save and Restore:
+// colours, line width, text size and coordinate system.
+type state struct {
+	stroke   string
+	fill     string
+	width    float64 // in viewBox units
+	textSize float64 // in viewBox units
+
+	// viewBox x = (x + offsetX) * scaleX, likewise for y.
+	scaleX, scaleY   float64
+	offsetX, offsetY float64
+}
+
+// New returns a new Graphics with black stroke and fill colour and the
+// pen at the origin.
+func New() *Graphics {
+	g := &Graphics{state: state{
+		stroke:   "#000000",
+		fill:     "#000000",
+		width:    defaultWidth,
+		textSize: defaultTextSize,
+	}}
+	g.Coordinates(0, 0, size, size)
+	g.Move(0, 0)
+	return g
+}
+
+// Runtime returns the graphics runtime for evaluator.Runtime, drawing
+// to g.
+func (g *Graphics) Runtime() evaluator.GraphicsRuntime {
+	return evaluator.GraphicsRuntime{
+		Move:        g.Move,
+		Line:        g.Line,
+		Rect:        g.Rect,
+		Circle:      g.Circle,
+		Width:       g.Width,
+		Color:       g.Color,
+		Stroke:      g.Stroke,
+		Fill:        g.Fill,
+		Polygon:     g.Polygon,
+		Polyline:    g.Polyline,
+		Curve:       g.Curve,
+		Text:        g.Text,
+		TextSize:    g.TextSize,
+		Clear:       g.Clear,
+		Save:        g.Save,
+		Restore:     g.Restore,
+		Coordinates: g.Coordinates,
+	}
+}
+
+// WriteTo writes the SVG document to w, implementing io.WriterTo.
+func (g *Graphics) WriteTo(w io.Writer) (int64, error) {
+	var sb strings.Builder
+	sb.WriteString(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 100 100" width="500" height="500">` + "\n")
+	for _, el := range g.elements {
+		sb.WriteString("  " + el + "\n")
+	}
+	sb.WriteString("</svg>\n")
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
+
+func (g *Graphics) Move(x, y float64) {
+	g.x, g.y = g.viewX(x), g.viewY(y)
+}
+
+func (g *Graphics) Line(x, y float64) {
+	x2, y2 := g.viewX(x), g.viewY(y)
+	attrs := xy("x1", "y1", g.x, g.y) + xy("x2", "y2", x2, y2) + g.strokeAttrs()
+	g.add("line", attrs)
+	g.x, g.y = x2, y2
+}
+
+func (g *Graphics) Rect(dx, dy float64) {
+	x2, y2 := g.x+dx*g.scaleX, g.y+dy*g.scaleY
+	attrs := xy("x", "y", math.Min(g.x, x2), math.Min(g.y, y2)) +
+		attr("width", math.Abs(x2-g.x)) + attr("height", math.Abs(y2-g.y))
+	g.add("rect", attrs+g.shapeAttrs())
+	g.x, g.y = x2, y2
+}
+
+func (g *Graphics) Circle(radius float64) {
+	attrs := xy("cx", "cy", g.x, g.y) + attr("r", g.viewLen(radius))
+	g.add("circle", attrs+g.shapeAttrs())
+}
+
+func (g *Graphics) Width(w float64) {
+	g.width = g.viewLen(w)
+}
+
+func (g *Graphics) Color(s string) {
+	g.stroke = s
+	g.fill = s
+}
+
+func (g *Graphics) Stroke(s string) {
+	g.stroke = s
+}
+
+func (g *Graphics) Fill(s string) {
+	g.fill = s
+}
+
+func (g *Graphics) Polygon(vertices [][]float64) {
+	g.add("polygon", g.points(vertices)+g.shapeAttrs())
+}
+
+func (g *Graphics) Polyline(vertices [][]float64) {
+	g.add("polyline", g.points(vertices)+g.strokeAttrs()+` fill="none"`)
+}
+
+// Curve draws a quadratic Bézier curve from the current position to
+// (x, y) with control point (cx, cy).
+func (g *Graphics) Curve(cx, cy, x, y float64) {
+	x2, y2 := g.viewX(x), g.viewY(y)
+	d := "M " + num(g.x) + " " + num(g.y) +
+		" Q " + num(g.viewX(cx)) + " " + num(g.viewY(cy)) +
+		" " + num(x2) + " " + num(y2)
+	g.add("path", ` d="`+d+`"`+g.strokeAttrs()+` fill="none"`)
+	g.x, g.y = x2, y2
+}
+
+// Text writes s with its baseline starting at the current position.
+func (g *Graphics) Text(s string) {
+	attrs := xy("x", "y", g.x, g.y) + attr("font-size", g.textSize) +
+		` font-family="sans-serif"` + strAttr("fill", g.fill)
+	g.elements = append(g.elements, "<text"+attrs+">"+escape(s)+"</text>")
+}
+
+func (g *Graphics) TextSize(textSize float64) {
+	g.textSize = g.viewLen(textSize)
+}
+
+// Clear removes all elements drawn so far.
+func (g *Graphics) Clear() {
+	g.elements = nil
+}
+
+func (g *Graphics) Save() {
+	g.saved = append(g.saved, g.state)
+}
+
+// Restore restores the most recently saved drawing state. It does
+// nothing if there is no saved state, like on the browser canvas.
+func (g *Graphics) Restore() {
+	if len(g.saved) == 0 {
+		return
+	}
+	g.state = g.saved[len(g.saved)-1]
+	g.saved = g.saved[:len(g.saved)-1]
+}
+
+// Coordinates sets the coordinate system to span minX to maxX and minY
+// to maxY, with the y-axis pointing up if minY < maxY.
+func (g *Graphics) Coordinates(minX, minY, maxX, maxY float64) {
+	g.scaleX = size / (maxX - minX)
+	g.scaleY = -size / (maxY - minY)
+	g.offsetX = -minX
+	g.offsetY = -maxY
+}
+
+func (g *Graphics) viewX(x float64) float64 {
+	return (x + g.offsetX) * g.scaleX
+}
+
+func (g *Graphics) viewY(y float64) float64 {
+	return (y + g.offsetY) * g.scaleY
+}
+
+// viewLen converts a length, e.g. a radius, to viewBox units, using the
+// horizontal scale.
+func (g *Graphics) viewLen(l float64) float64 {
+	return math.Abs(l * g.scaleX)
+}
+
+func (g *Graphics) add(name, attrs string) {
+	g.elements = append(g.elements, "<"+name+attrs+" />")
+}
+
+func (g *Graphics) strokeAttrs() string {
+	return strAttr("stroke", g.stroke) + attr("stroke-width", g.width)
+}
+
+func (g *Graphics) shapeAttrs() string {
+	return strAttr("fill", g.fill) + g.strokeAttrs()
+}
+
+func (g *Graphics) points(vertices [][]float64) string {
+	pts := make([]string, len(vertices))
+	for i, v := range vertices {
+		pts[i] = num(g.viewX(v[0])) + "," + num(g.viewY(v[1]))
+	}
+	return strAttr("points", strings.Join(pts, " "))
+}
+
+func xy(xName, yName string, x, y float64) string {
+	return attr(xName, x) + attr(yName, y)
+}
+
+func attr(name string, val float64) string {
+	return strAttr(name, num(val))
+}
+
+func strAttr(name, val string) string {
+	return " " + name + `="` + escape(val) + `"`
+}
+
+// num formats n rounded to 2 decimal places, 1/10 of a pixel on the
+// browser canvas.
+func num(n float64) string {
+	n = math.Round(n*100) / 100
+	if n == 0 {
+		n = 0 // avoid "-0"
+	}
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+var escaper = strings.NewReplacer(`&`, "&amp;", `<`, "&lt;", `>`, "&gt;", `"`, "&quot;", `'`, "&#39;")
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
@@ -0,0 +1,93 @@
+package svg
+
+import (
+	"strings"
+	"testing"
+
+	"foxygo.at/evy/pkg/assert"
+	"foxygo.at/evy/pkg/evaluator"
+)
+
+func TestSVG(t *testing.T) {
+	prog := `
+move 10 10
+line 20 30
+color "red"
+rect 20 -5
+move 50 50
+width 2
+fill "none"
+circle 10
+stroke "Blue"
+fill "#00ff00"
+polygon [0 0] [10 0] [10 10.123]
+polyline [1 2] [3 4]
+move 0 0
+curve 50 100 100 0
+textsize 4.5
+text "a<b & \"c\""`
+	want := `
+<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 100 100" width="500" height="500">
+  <line x1="10" y1="90" x2="20" y2="70" stroke="#000000" stroke-width="0.1" />
+  <rect x="20" y="70" width="20" height="5" fill="#ff0000" stroke="#ff0000" stroke-width="0.1" />
+  <circle cx="50" cy="50" r="10" fill="none" stroke="#ff0000" stroke-width="2" />
+  <polygon points="0,100 10,100 10,89.88" fill="#00ff00" stroke="#0000ff" stroke-width="2" />
+  <polyline points="1,98 3,96" stroke="#0000ff" stroke-width="2" fill="none" />
+  <path d="M 0 100 Q 50 0 100 100" stroke="#0000ff" stroke-width="2" fill="none" />
+  <text x="100" y="100" font-size="4.5" font-family="sans-serif" fill="#00ff00">a&lt;b &amp; &quot;c&quot;</text>
+</svg>
+`[1:]
+	g := New()
+	rt := evaluator.Runtime{Print: func(s string) {}, Graphics: g.Runtime()}
+	err := evaluator.RunWithBuiltinsErr(prog, evaluator.DefaultBuiltins(rt))
+	assert.NoError(t, err)
+	var sb strings.Builder
+	n, err := g.WriteTo(&sb)
+	assert.NoError(t, err)
+	assert.Equal(t, want, sb.String())
+	assert.Equal(t, int64(len(want)), n)
+}
+
+func TestEmpty(t *testing.T) {
+	var sb strings.Builder
+	_, err := New().WriteTo(&sb)
+	assert.NoError(t, err)
+	want := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 100 100" width="500" height="500">` + "\n</svg>\n"
+	assert.Equal(t, want, sb.String())
+}
+
+func TestStateAndCoordinates(t *testing.T) {
+	prog := `
+line 50 50
+clear
+save
+coordinates -1 -1 1 1
+color "red"
+width 0.1
+move 0 0
+circle 0.5
+line 1 1
+restore
+line 100 50
+restore
+coordinates 0 0 10 10
+textsize 1
+move 0 10
+text "top"`
+	want := `
+<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 100 100" width="500" height="500">
+  <circle cx="50" cy="50" r="25" fill="#ff0000" stroke="#ff0000" stroke-width="5" />
+  <line x1="50" y1="50" x2="100" y2="0" stroke="#ff0000" stroke-width="5" />
+  <line x1="100" y1="0" x2="100" y2="50" stroke="#000000" stroke-width="0.1" />
+  <text x="0" y="0" font-size="10" font-family="sans-serif" fill="#000000">top</text>
+</svg>
+`[1:]
+	g := New()
+	rt := evaluator.Runtime{Print: func(s string) {}, Graphics: g.Runtime()}
+	err := evaluator.RunWithBuiltinsErr(prog, evaluator.DefaultBuiltins(rt))
+	assert.NoError(t, err)
+	var sb strings.Builder
+	_, err = g.WriteTo(&sb)
+	assert.NoError(t, err)
+	assert.Equal(t, want, sb.String())
+}
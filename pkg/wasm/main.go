@@ -4,6 +4,7 @@ package main
 
 import (
 	"strings"
+	"time"
 	"unsafe"
 
 	"foxygo.at/evy/pkg/evaluator"
@@ -44,6 +45,67 @@ func width(w float64)
 //export color
 func color(s string)
 
+// stroke is imported from JS, setting the strokeStyle
+//export stroke
+func stroke(s string)
+
+// fill is imported from JS, setting the fillStyle
+//export fill
+func fill(s string)
+
+// polygon is imported from JS. xy holds n coordinates of the vertices:
+// x1 y1 x2 y2 ...
+//export polygon
+func polygon(xy *float64, n int)
+
+// polyline is imported from JS, see polygon for parameters
+//export polyline
+func polyline(xy *float64, n int)
+
+// curve is imported from JS
+//export curve
+func curve(cx, cy, x, y float64)
+
+// text is imported from JS
+//export text
+func text(s string)
+
+// textsize is imported from JS, setting the font size
+//export textsize
+func textsize(size float64)
+
+// clear is imported from JS
+//export clear
+func clear()
+
+// save is imported from JS
+//export save
+func save()
+
+// restore is imported from JS
+//export restore
+func restore()
+
+// coordinates is imported from JS
+//export coordinates
+func coordinates(minX, minY, maxX, maxY float64)
+
+// readid is imported from JS. It writes the value of the DOM element
+// with the given query selector to buf, up to size bytes, and returns
+// the full length of the value in bytes.
+//export readid
+func readid(selector string, buf *byte, size int) int
+
+// registerEventHandler is imported from JS. It adds DOM event
+// listeners for the given evy event, e.g. key_press.
+//export registerEventHandler
+func registerEventHandler(name string)
+
+// sleep is imported from JS. It returns immediately and calls the
+// exported wakeup function after ms milliseconds.
+//export sleep
+func sleep(ms float64)
+
 // We cannot take the address of external/exported functions
 // (https://golang.org/cmd/cgo/#hdr-Passing_pointers) so we must wrap them in a
 // Go function first to put them in this Runtime struct.
@@ -56,7 +118,147 @@ var jsRuntime evaluator.Runtime = evaluator.Runtime{
 		Circle: func(r float64) { circle(r) },
 		Width:  func(w float64) { width(w) },
 		Color:  func(s string) { color(s) },
+		Stroke: func(s string) { stroke(s) },
+		Fill:   func(s string) { fill(s) },
+		Polygon: func(vertices [][]float64) {
+			if xy := flatten(vertices); len(xy) > 0 {
+				polygon(&xy[0], len(xy))
+			}
+		},
+		Polyline: func(vertices [][]float64) {
+			if xy := flatten(vertices); len(xy) > 0 {
+				polyline(&xy[0], len(xy))
+			}
+		},
+		Curve:    func(cx, cy, x, y float64) { curve(cx, cy, x, y) },
+		Text:     func(s string) { text(s) },
+		TextSize: func(size float64) { textsize(size) },
+		Clear:    func() { clear() },
+		Save:     func() { save() },
+		Restore:  func() { restore() },
+		Coordinates: func(minX, minY, maxX, maxY float64) {
+			coordinates(minX, minY, maxX, maxY)
+		},
 	},
+	ReadID: readID,
+}
+
+// maxQueuedEvents is the number of events queued for the event loop.
+// Further events, e.g. frames while a slow frame handler runs, are
+// dropped.
+const maxQueuedEvents = 16
+
+// run is a single run of an evy program. Exported functions must not
+// block, so that the browser can paint and handle input, therefore the
+// program is evaluated in its own goroutine. When it blocks, e.g. in
+// sleep, control returns to JS. run is the evaluator.Clock of the
+// program.
+type run struct {
+	eval    *evaluator.Evaluator
+	events  chan evaluator.Event // events queued by JS, see queueEvent
+	wake    chan struct{}        // signalled by JS after sleep, see wakeup
+	done    chan struct{}        // closed by stop
+	stopped bool                 // true after the program has finished or stop
+}
+
+// current is the run of the most recently evaluated evy program.
+var current *run
+
+func newRun() *run {
+	r := &run{
+		events: make(chan evaluator.Event, maxQueuedEvents),
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	rt := jsRuntime
+	rt.Clock = r
+	r.eval = evaluator.NewEvaluator(evaluator.DefaultBuiltins(rt))
+	return r
+}
+
+func (r *run) Now() time.Time { return time.Now() }
+
+// Sleep blocks the goroutine of r until JS calls wakeup or r is
+// stopped, returning control to JS in the meantime.
+func (r *run) Sleep(d time.Duration) {
+	sleep(float64(d) / float64(time.Millisecond))
+	select {
+	case <-r.wake:
+	case <-r.done:
+	}
+}
+
+// exec evaluates the top-level code of the evy program source and then
+// runs its event loop. Runtime errors are printed.
+func (r *run) exec(source string) {
+	err := r.eval.Run(source)
+	if err == nil {
+		err = r.eventLoop()
+	}
+	if err != nil && !evaluator.IsExit(err) {
+		jsPrint(err.Error())
+	}
+	r.stopped = true
+}
+
+// eventLoop registers the program's event handlers with JS and calls
+// them for the events queued by JS until the run is stopped.
+func (r *run) eventLoop() error {
+	names := r.eval.EventHandlerNames()
+	for _, name := range names {
+		registerEventHandler(name)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	for {
+		select {
+		case ev := <-r.events:
+			if err := r.eval.HandleEvent(ev); err != nil {
+				return err
+			}
+		case <-r.done:
+			return nil
+		}
+	}
+}
+
+// stop stops the run, also if it is currently sleeping.
+func (r *run) stop() {
+	if r.stopped {
+		return
+	}
+	r.stopped = true
+	r.eval.Stop()
+	close(r.done)
+}
+
+// queueEvent queues ev for the event loop without blocking and reports
+// whether the program is still running.
+func queueEvent(ev evaluator.Event) bool {
+	r := current
+	if r == nil || r.stopped {
+		return false
+	}
+	select {
+	case r.events <- ev:
+	default: // drop event, queue is full
+	}
+	return true
+}
+
+// wakeup is exported to JS and called when the time passed to the
+// imported sleep function has elapsed.
+//
+//export wakeup
+func wakeup() {
+	if current == nil {
+		return
+	}
+	select {
+	case current.wake <- struct{}{}:
+	default:
+	}
 }
 
 // evaluate evaluates an evy program, after tokenizing and parsing. It
@@ -66,11 +268,75 @@ var jsRuntime evaluator.Runtime = evaluator.Runtime{
 // * https://www.wasm.builders/k33g_org/an-essay-on-the-bi-directional-exchange-of-strings-between-the-wasm-module-with-tinygo-and-nodejs-with-wasi-support-3i9h
 // * https://www.alcarney.me/blog/2020/passing-strings-between-tinygo-wasm/
 //
+// The previous run, if any, is stopped and the program is evaluated in
+// a new goroutine, see run.
+//
 //export evaluate
 func jsEvaluate(ptr *uint32, length int) {
 	s := getString(ptr, length)
-	builtins := evaluator.DefaultBuiltins(jsRuntime)
-	evaluator.RunWithBuiltins(s, builtins)
+	jsStop()
+	current = newRun()
+	go current.exec(s)
+}
+
+// stop is exported to JS and stops the current run, if any. It is
+// called before tokenizing, parsing or evaluating, which reset the
+// output and event handlers of the current run.
+//
+//export stop
+func jsStop() {
+	if current != nil {
+		current.stop()
+	}
+}
+
+// onKeyPress is exported to JS and called on keydown events if the
+// evy program has a key_press event handler.
+//
+//export onKeyPress
+func onKeyPress(ptr *uint32, length int) {
+	key := &evaluator.String{Val: getString(ptr, length)}
+	queueEvent(evaluator.Event{Name: "key_press", Params: []evaluator.Value{key}})
+}
+
+// onMouseDown is exported to JS and called on mousedown events if the
+// evy program has a mouse_down event handler.
+//
+//export onMouseDown
+func onMouseDown(x, y float64) {
+	queueEvent(mouseEvent("mouse_down", x, y))
+}
+
+// onMouseUp is exported to JS and called on mouseup events if the
+// evy program has a mouse_up event handler.
+//
+//export onMouseUp
+func onMouseUp(x, y float64) {
+	queueEvent(mouseEvent("mouse_up", x, y))
+}
+
+// onMouseMove is exported to JS and called on mousemove events if the
+// evy program has a mouse_move event handler.
+//
+//export onMouseMove
+func onMouseMove(x, y float64) {
+	queueEvent(mouseEvent("mouse_move", x, y))
+}
+
+// onFrame is exported to JS and called on every animation frame if the
+// evy program has a frame event handler. elapsed is the time since the
+// first frame in seconds. It returns false if no further frames should
+// be requested, e.g. after the exit builtin.
+//
+//export onFrame
+func onFrame(elapsed float64) bool {
+	d := time.Duration(elapsed * float64(time.Second))
+	return queueEvent(evaluator.FrameEvent(d))
+}
+
+func mouseEvent(name string, x, y float64) evaluator.Event {
+	params := []evaluator.Value{&evaluator.Num{Val: x}, &evaluator.Num{Val: y}}
+	return evaluator.Event{Name: name, Params: params}
 }
 
 //export tokenize
@@ -86,6 +352,31 @@ func jsParse(ptr *uint32, length int) {
 	jsPrint(parser.Run(s, builtins))
 }
 
+// readID reads the value of the DOM element with the given query
+// selector via JS, retrying with a larger buffer if needed.
+func readID(selector string) string {
+	buf := make([]byte, 256)
+	n := readid(selector, &buf[0], len(buf))
+	if n > len(buf) {
+		buf = make([]byte, n)
+		n = readid(selector, &buf[0], len(buf))
+	}
+	if n > len(buf) {
+		n = len(buf) // value changed in between calls
+	}
+	return string(buf[:n])
+}
+
+// flatten returns the coordinates of vertices as a single slice,
+// x1 y1 x2 y2 ..., to be passed to JS via linear memory.
+func flatten(vertices [][]float64) []float64 {
+	xy := make([]float64, 0, 2*len(vertices))
+	for _, v := range vertices {
+		xy = append(xy, v[0], v[1])
+	}
+	return xy
+}
+
 // alloc pre-allocates memory used in string parameter passing.
 //
 //export alloc